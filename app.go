@@ -1,14 +1,20 @@
 package worq
 
 import (
+	stdcontext "context"
 	"errors"
 	"fmt"
+	"os"
+	"runtime/debug"
 	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 
 	"github.com/gofrs/uuid"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type TaskRejected struct {
@@ -19,6 +25,17 @@ func (t TaskRejected) Error() string {
 	return fmt.Sprintf("worq: task rejected; requeue: %v", t.Requeue)
 }
 
+// TaskPanicked is returned when a task panics. It holds the value passed to
+// panic and the stack trace of the goroutine.
+type TaskPanicked struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (t TaskPanicked) Error() string {
+	return fmt.Sprintf("worq: task panicked: %v", t.Value)
+}
+
 type TaskNotFound struct {
 	Name string
 }
@@ -31,16 +48,52 @@ func (t TaskNotFound) Error() string {
 type OptionFunc func(*App) error
 
 type App struct {
+	eventClock uint64 // accessed atomically; keep 64-bit aligned
+
 	logger   logrus.FieldLogger
 	broker   Broker
 	protocol Protocol
 	binder   Binder
 
 	concurrency  int
+	acksLate     bool
 	defaultQueue string
 	idFunc       func() string
+	chordStore   ChordStore
+
+	// chordStoreWarning warns once that chords are tracked in memory
+	chordStoreWarning sync.Once
+
+	rateLimiter   RateLimiter
+	semaphore     Semaphore
+	holdBackDelay time.Duration
+	lockStore     LockStore
+
+	processedStore ProcessedStore
 
-	taskMap sync.Map // map[string]TaskFunc
+	hostname        string
+	controlExchange string
+	revokeStore     RevokeStore
+
+	sendEvents        bool
+	eventExchange     string
+	heartbeatInterval time.Duration
+	eventListeners    []EventListener
+
+	tracerProvider trace.TracerProvider
+	propagator     propagation.TextMapPropagator
+
+	middleware []MiddlewareFunc
+
+	enqueueInterceptors []EnqueueInterceptor
+	enqueue             EnqueueFunc
+
+	taskMap sync.Map // map[string]*task
+	active  sync.Map // map[string]*activeTask
+	waiting sync.Map // map[string]stdcontext.CancelFunc
+
+	workerMu sync.Mutex
+	worker   *worker
 }
 
 func New(options ...OptionFunc) (*App, error) {
@@ -53,10 +106,26 @@ func New(options ...OptionFunc) (*App, error) {
 	app := new(App)
 	app.logger = logger
 	app.concurrency = 4
+	app.acksLate = true
 	app.defaultQueue = "worq"
 	app.idFunc = func() string {
 		return uuid.Must(uuid.NewV4()).String()
 	}
+	app.chordStore = NewMemoryChordStore()
+	app.hostname = "worq@" + hostname()
+	app.controlExchange = "worq.pidbox"
+	app.revokeStore = NewMemoryRevokeStore(50000)
+	app.rateLimiter = NewMemoryRateLimiter()
+	app.semaphore = NewMemorySemaphore()
+	app.lockStore = NewMemoryLockStore()
+	app.processedStore = NewMemoryProcessedStore(50000)
+	app.holdBackDelay = time.Second
+	app.eventExchange = "celeryev"
+	app.heartbeatInterval = 2 * time.Second
+	app.propagator = propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	)
 
 	// Apply option functions
 	for _, option := range options {
@@ -65,12 +134,25 @@ func New(options ...OptionFunc) (*App, error) {
 		}
 	}
 
+	app.enqueue = chainEnqueue(func(ctx stdcontext.Context, sig *Signature, pub *Publishing) error {
+		return app.publish(ctx, pub)
+	}, app.enqueueInterceptors...)
+
 	return app, nil
 }
 
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return name
+}
+
 func (app *App) Context() Context {
 	return &context{
-		app: app,
+		Context: stdcontext.Background(),
+		app:     app,
 	}
 }
 
@@ -78,7 +160,10 @@ func (app *App) Protocol() Protocol {
 	return app.protocol
 }
 
-func (app *App) Register(name string, f TaskFunc) error {
+// Register registers the task function under name. Middleware added with Use
+// wraps every task, outermost first, followed by the middleware given to the
+// task with WithMiddleware.
+func (app *App) Register(name string, f TaskFunc, options ...TaskOptionFunc) error {
 	if name == "" {
 		return errors.New("worq.Register: task name is empty")
 	}
@@ -87,7 +172,20 @@ func (app *App) Register(name string, f TaskFunc) error {
 		return errors.New("worq.Register: task function is nil")
 	}
 
-	if _, dup := app.taskMap.LoadOrStore(name, f); dup {
+	t := &task{
+		name: name,
+		f:    f,
+	}
+
+	for _, option := range options {
+		if err := option(t); err != nil {
+			return err
+		}
+	}
+
+	t.handler = chain(f, append(append([]MiddlewareFunc(nil), app.middleware...), t.middleware...)...)
+
+	if _, dup := app.taskMap.LoadOrStore(name, t); dup {
 		return errors.New("worq.Register: task already defined: " + name)
 	}
 	return nil
@@ -96,100 +194,542 @@ func (app *App) Register(name string, f TaskFunc) error {
 func (app *App) Start() error {
 	app.logger.Info("Watch this space")
 
-	g, ctx := errgroup.WithContext(app.Context())
+	ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	defer cancel()
 
-	for i := 0; i < 10; i++ {
+	g, ctx := errgroup.WithContext(ctx)
+
+	// The event publisher is set up before any goroutine can send events,
+	// and drained once they have all returned
+	var events *eventPublisher
+	if b, ok := app.broker.(Broadcaster); ok && app.sendEvents {
+		events = newEventPublisher(app, b, 1024)
+		defer events.close()
+	}
+
+	w := newWorker(app, ctx, g, cancel, events)
+	app.setWorker(w)
+	defer app.setWorker(nil)
+
+	if b, ok := app.broker.(Broadcaster); ok {
 		g.Go(func() error {
-			consumer, err := app.broker.Consume(app.Context(), app.defaultQueue)
-			if err != nil {
-				return err
-			}
+			return app.consumeControl(ctx, b)
+		})
+	}
 
-			for {
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
+	if err := w.addConsumer(app.defaultQueue); err != nil {
+		cancel()
+		g.Wait()
+		return err
+	}
+	w.resize(app.concurrency)
 
-				default:
-					if !consumer.Next() {
-						return consumer.Err()
-					}
+	if app.sendEvents {
+		app.sendWorkerEvent(EventWorkerOnline, w)
+		defer app.sendWorkerEvent(EventWorkerOffline, w)
 
-					if err := app.consumerOnNext(consumer); err != nil {
-						app.logger.Errorf("error consuming message: %v", err)
-					}
-				}
-			}
+		g.Go(func() error {
+			return app.heartbeat(ctx, w)
 		})
 	}
 
-	return g.Wait()
+	if err := g.Wait(); err != nil && err != stdcontext.Canceled {
+		return err
+	}
+	return nil
+}
+
+func (app *App) setWorker(w *worker) {
+	app.workerMu.Lock()
+	defer app.workerMu.Unlock()
+	app.worker = w
+}
+
+// currentWorker returns the worker run by Start, or nil if the App is not
+// started.
+func (app *App) currentWorker() *worker {
+	app.workerMu.Lock()
+	defer app.workerMu.Unlock()
+	return app.worker
 }
 
-func (app *App) consumerOnNext(consumer Consumer) error {
-	// TODO: Convert this into a consumer struct
+func (app *App) handleMessage(consumer Consumer, msg Message) error {
+	taskCtx, span := app.startTaskSpan(stdcontext.Background(), msg)
+	taskCtx, cancel := stdcontext.WithCancel(taskCtx)
+	defer cancel()
+
+	// TODO: message specific context?
+	ctx := &context{
+		Context: taskCtx,
+		app:     app,
+		logger: app.logger.WithFields(logrus.Fields{
+			"id":   msg.ID(),
+			"task": msg.Task(),
+		}),
+		consumer: consumer,
+		msg:      msg,
+	}
+
+	ctx.Logger().Info("Task received")
+	app.sendEvent(EventTaskReceived, msg, Event{
+		"uuid":        msg.ID(),
+		"name":        msg.Task(),
+		"routing_key": msg.Queue(),
+	})
+
+	if app.isRevoked(msg.ID()) {
+		ctx.logger.Warn("Discarding revoked task")
+		app.sendEvent(EventTaskRevoked, msg, Event{
+			"uuid":       msg.ID(),
+			"terminated": false,
+		})
+		endSpan(span, nil)
+		return consumer.Ack(msg)
+	}
 
-	if msg, err := consumer.Message(); err == nil {
-		// TODO: message specific context?
-		ctx := &context{
-			app: app,
-			logger: app.logger.WithFields(logrus.Fields{
-				"id":   msg.ID(),
-				"task": msg.Task(),
-			}),
-			consumer: consumer,
-			msg:      msg,
+	if app.isProcessed(msg) {
+		ctx.logger.Warn("Discarding duplicate of processed task")
+		endSpan(span, nil)
+		return consumer.Ack(msg)
+	}
+
+	// Wait for the rate limit of the task while holding on to the message,
+	// but before taking a concurrency slot so that the wait does not hold one.
+	// The task may be revoked in the meantime, which cancels the wait if it
+	// terminates the task.
+	app.waiting.Store(msg.ID(), cancel)
+	err := app.waitRateLimit(taskCtx, msg.Task())
+	app.waiting.Delete(msg.ID())
+
+	if app.isRevoked(msg.ID()) {
+		ctx.logger.Warn("Discarding revoked task")
+		app.sendEvent(EventTaskRevoked, msg, Event{
+			"uuid":       msg.ID(),
+			"terminated": false,
+		})
+		endSpan(span, nil)
+		return consumer.Ack(msg)
+	}
+	if err != nil {
+		endSpan(span, err)
+		return consumer.Nack(msg, true)
+	}
+
+	acquired, err := app.acquireConcurrency(taskCtx, msg)
+	if err != nil {
+		ctx.logger.Errorf("error acquiring concurrency limit: %v", err)
+		endSpan(span, err)
+		return consumer.Nack(msg, true)
+	}
+	if !acquired {
+		ctx.logger.Debug("Task held back by its concurrency limit")
+		endSpan(span, nil)
+		app.holdBack(consumer, msg)
+		return nil
+	}
+	defer app.releaseConcurrency(msg)
+
+	if !app.acksLate {
+		// The message is acknowledged before the task runs, so it is not
+		// redelivered even if the task fails or the worker dies
+		if err := consumer.Ack(msg); err != nil {
+			endSpan(span, err)
+			return err
 		}
+		consumer = ackedConsumer{consumer}
+		ctx.consumer = consumer
+	}
 
-		ctx.Logger().Info("Task received")
+	// A unique task may be enqueued again once it has started
+	app.releaseUnique(msg)
+
+	started := time.Now()
+	app.sendEvent(EventTaskStarted, msg, Event{
+		"uuid": msg.ID(),
+	})
+
+	app.active.Store(msg.ID(), &activeTask{
+		id:      msg.ID(),
+		name:    msg.Task(),
+		queue:   msg.Queue(),
+		started: started,
+		cancel:  cancel,
+	})
+	err = app.processMessage(ctx)
+	app.active.Delete(msg.ID())
+	endSpan(span, err)
+
+	if err != nil && app.isRevoked(msg.ID()) {
+		ctx.logger.Warn("Task terminated")
+		app.sendEvent(EventTaskRevoked, msg, Event{
+			"uuid":       msg.ID(),
+			"terminated": true,
+		})
+		return consumer.Ack(msg)
+	}
 
-		switch err := app.processMessage(ctx).(type) {
-		case nil:
-			return consumer.Ack(msg)
-		case *TaskNotFound:
-			ctx.logger.Error(err)
-			return consumer.Nack(msg, false)
-		case *TaskRejected:
-			ctx.logger.Warn(err)
-			return consumer.Nack(msg, err.Requeue)
-		default:
-			ctx.logger.Error(err)
-			return consumer.Nack(msg, true) // or requeue = false?
-		}
-	} else {
+	switch err := err.(type) {
+	case nil:
+		if err := app.enqueueWorkflow(ctx); err != nil {
+			// The rest of the workflow would be lost, so the task is retried
+			ctx.logger.Errorf("error enqueuing workflow: %v", err)
+			app.sendEvent(EventTaskRetried, msg, Event{
+				"uuid":      msg.ID(),
+				"exception": err.Error(),
+				"runtime":   time.Since(started).Seconds(),
+			})
+			return consumer.Nack(msg, true)
+		}
+		app.sendEvent(EventTaskSucceeded, msg, Event{
+			"uuid":    msg.ID(),
+			"result":  fmt.Sprintf("%v", ctx.result),
+			"runtime": time.Since(started).Seconds(),
+		})
+		app.markProcessed(msg)
+		return consumer.Ack(msg)
+	case *TaskNotFound:
+		ctx.logger.Error(err)
+		app.sendEvent(EventTaskFailed, msg, Event{
+			"uuid":      msg.ID(),
+			"exception": err.Error(),
+			"runtime":   time.Since(started).Seconds(),
+		})
+		return consumer.Nack(msg, false)
+	case *TaskPanicked:
+		ctx.logger.WithField("stack", string(err.Stack)).Error(err)
+		app.sendEvent(EventTaskFailed, msg, Event{
+			"uuid":      msg.ID(),
+			"exception": err.Error(),
+			"traceback": string(err.Stack),
+			"runtime":   time.Since(started).Seconds(),
+		})
+		if err := app.enqueueErrbacks(ctx, err); err != nil {
+			ctx.logger.Errorf("error enqueuing errbacks: %v", err)
+		}
+		// Panics are likely to happen again, so the message is dead-lettered
+		// rather than requeued
 		return consumer.Nack(msg, false)
+	case *TaskRejected:
+		ctx.logger.Warn(err)
+		// A message that has already been acknowledged cannot be requeued
+		requeue := err.Requeue && app.acksLate
+		app.sendEvent(EventTaskRejected, msg, Event{
+			"uuid":    msg.ID(),
+			"requeue": requeue,
+		})
+		if !requeue {
+			if err := app.enqueueErrbacks(ctx, err); err != nil {
+				ctx.logger.Errorf("error enqueuing errbacks: %v", err)
+			}
+		}
+		return consumer.Nack(msg, requeue)
+	default:
+		ctx.logger.Error(err)
+		if !app.acksLate {
+			// The message has already been acknowledged, so the task is not
+			// retried
+			app.sendEvent(EventTaskFailed, msg, Event{
+				"uuid":      msg.ID(),
+				"exception": err.Error(),
+				"runtime":   time.Since(started).Seconds(),
+			})
+			if err := app.enqueueErrbacks(ctx, err); err != nil {
+				ctx.logger.Errorf("error enqueuing errbacks: %v", err)
+			}
+			return consumer.Nack(msg, false)
+		}
+		// The message is requeued, so the task will be retried. Errbacks
+		// are only enqueued once it fails for good.
+		app.sendEvent(EventTaskRetried, msg, Event{
+			"uuid":      msg.ID(),
+			"exception": err.Error(),
+			"runtime":   time.Since(started).Seconds(),
+		})
+		return consumer.Nack(msg, true)
 	}
 }
 
-func (app *App) processMessage(ctx Context) error {
-	f, ok := app.taskMap.Load(ctx.Message().Task())
+// ackedConsumer is a Consumer whose message has already been acknowledged.
+type ackedConsumer struct {
+	Consumer
+}
+
+func (c ackedConsumer) Ack(msg Message) error {
+	return nil
+}
+
+func (c ackedConsumer) Nack(msg Message, requeue bool) error {
+	return nil
+}
+
+func (app *App) isRevoked(id string) bool {
+	revoked, err := app.revokeStore.Contains(id)
+	if err != nil {
+		app.logger.Errorf("error checking revoked tasks: %v", err)
+	}
+	return revoked
+}
+
+func (app *App) processMessage(ctx Context) (err error) {
+	t, ok := app.taskMap.Load(ctx.Message().Task())
 	if !ok {
 		return &TaskNotFound{ctx.Message().Task()}
 	}
-	return f.(TaskFunc)(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = &TaskPanicked{
+				Value: r,
+				Stack: debug.Stack(),
+			}
+		}
+	}()
+
+	return t.(*task).handler(ctx)
+}
+
+// enqueueWorkflow enqueues the callbacks of a successful task, followed by the
+// next task of the chain, or the chord callback once every task of the group
+// has completed.
+func (app *App) enqueueWorkflow(ctx *context) error {
+	if app.binder == nil {
+		return nil
+	}
+
+	sig, err := app.binder.Signature(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, callback := range sig.Callbacks {
+		if _, err := app.EnqueueContext(ctx, callback.withParentResult(ctx.result)); err != nil {
+			return err
+		}
+	}
+
+	if len(sig.Chain) > 0 {
+		next := sig.Chain[0].withParentResult(ctx.result)
+		next.Chain = append(next.Chain, sig.Chain[1:]...)
+
+		// The chord part completes with the last task of the chain
+		next.Chord = sig.Chord
+		next.Group = sig.Group
+		next.GroupIndex = sig.GroupIndex
+		next.GroupSize = sig.GroupSize
+
+		_, err := app.EnqueueContext(ctx, next)
+		return err
+	}
+
+	if sig.Chord != nil && sig.Group != "" {
+		if _, ok := app.chordStore.(*memoryChordStore); ok {
+			app.chordStoreWarning.Do(func() {
+				ctx.logger.Warn("Chords are tracked in memory, so they only complete when every task of the header runs in this process; use SetChordStore to share them between workers")
+			})
+		}
+
+		results, done, err := app.chordStore.Add(sig.Group, sig.GroupIndex, sig.GroupSize, ctx.result)
+		if err != nil {
+			return err
+		}
+		if done {
+			_, err := app.EnqueueContext(ctx, sig.Chord.withParentResult(results))
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enqueueErrbacks enqueues the errbacks of a task that failed for good, with
+// its ID and error message as their parent.
+func (app *App) enqueueErrbacks(ctx *context, taskErr error) error {
+	if app.binder == nil {
+		return nil
+	}
+
+	sig, err := app.binder.Signature(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, errback := range sig.Errbacks {
+		errback = errback.clone()
+		errback.ParentID = ctx.Message().ID()
+		errback.ParentError = taskErr.Error()
+		if _, err := app.EnqueueContext(ctx, errback); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (app *App) Enqueue(sig *Signature) (*AsyncResult, error) {
+	return app.EnqueueContext(stdcontext.Background(), sig)
+}
+
+// EnqueueContext enqueues the signature, propagating the trace context of ctx
+// to the task.
+func (app *App) EnqueueContext(ctx stdcontext.Context, sig *Signature) (*AsyncResult, error) {
+	result, _, err := app.enqueueSignature(ctx, sig)
+	return result, err
+}
+
+// enqueueSignature enqueues the signature and returns the unique key it
+// locked, if any.
+func (app *App) enqueueSignature(ctx stdcontext.Context, sig *Signature) (*AsyncResult, string, error) {
 	var err error
 
 	queue := app.queueForSignature(sig)
-	id := app.idFunc()
+	id := sig.ID
+	if id == "" {
+		id = app.idFunc()
+	}
+
+	var uniqueKey string
+	if sig.Unique > 0 {
+		var existing string
+		uniqueKey, existing, err = app.lockUnique(ctx, sig, id)
+		if err != nil {
+			return nil, "", err
+		}
+		if existing != "" {
+			return &AsyncResult{ID: existing}, "", nil
+		}
+	}
 
 	publishing, err := app.binder.Unbind(app.Context(), id, queue, sig)
 	if err != nil {
-		return nil, err
+		app.unlockUnique(uniqueKey, id)
+		return nil, "", err
+	}
+	if publishing.Priority == 0 {
+		publishing.Priority = app.taskPriority(sig.Task)
+	}
+	if uniqueKey != "" {
+		// The worker releases the key once the task starts
+		if publishing.Headers == nil {
+			publishing.Headers = make(map[string]interface{}, 1)
+		}
+		publishing.Headers[uniqueKeyHeader] = uniqueKey
 	}
 
-	err = app.broker.Enqueue(publishing)
+	span := app.startEnqueueSpan(ctx, id, sig, publishing)
+	err = app.enqueue(trace.ContextWithSpan(ctx, span), sig, publishing)
+	endSpan(span, err)
 	if err != nil {
-		return nil, err
+		app.unlockUnique(uniqueKey, id)
+		return nil, "", err
 	}
 
 	result := new(AsyncResult)
 	result.ID = id
+	return result, uniqueKey, nil
+}
+
+// EnqueueGroup enqueues the signatures to run in parallel.
+func (app *App) EnqueueGroup(sigs ...*Signature) (*GroupResult, error) {
+	return app.EnqueueGroupContext(stdcontext.Background(), sigs...)
+}
+
+// EnqueueGroupContext enqueues the signatures to run in parallel, propagating
+// the trace context of ctx to the tasks. If a signature fails to be enqueued,
+// the ones already enqueued are revoked.
+func (app *App) EnqueueGroupContext(ctx stdcontext.Context, sigs ...*Signature) (*GroupResult, error) {
+	if err := validateSignatures("worq.EnqueueGroup", sigs); err != nil {
+		return nil, err
+	}
+	return app.enqueueGroup(ctx, app.idFunc(), sigs, nil)
+}
+
+// EnqueueChord enqueues the header signatures to run in parallel, and body
+// once all of them have succeeded. The body receives the results of the
+// header as its first positional argument.
+//
+// The workers collect the results of the header in their ChordStore. The
+// default one keeps them in memory, so the body is never enqueued if the
+// header runs in more than one worker process; use SetChordStore with a
+// shared store in that case.
+func (app *App) EnqueueChord(header []*Signature, body *Signature) (*AsyncResult, error) {
+	return app.EnqueueChordContext(stdcontext.Background(), header, body)
+}
+
+// EnqueueChordContext is EnqueueChord, propagating the trace context of ctx
+// to the tasks. If a header signature fails to be enqueued, the ones already
+// enqueued are revoked, so the chord never runs.
+func (app *App) EnqueueChordContext(ctx stdcontext.Context, header []*Signature, body *Signature) (*AsyncResult, error) {
+	if body == nil {
+		return nil, errors.New("worq.EnqueueChord: body is nil")
+	}
+	if err := validateSignatures("worq.EnqueueChord", header); err != nil {
+		return nil, err
+	}
+
+	body = body.clone()
+	if body.ID == "" {
+		body.ID = app.idFunc()
+	}
+
+	if len(header) == 0 {
+		return app.EnqueueContext(ctx, body.withParentResult([]interface{}{}))
+	}
+
+	if _, err := app.enqueueGroup(ctx, app.idFunc(), header, body); err != nil {
+		return nil, err
+	}
+
+	result := new(AsyncResult)
+	result.ID = body.ID
 	return result, nil
 }
 
+func (app *App) enqueueGroup(ctx stdcontext.Context, id string, sigs []*Signature, chord *Signature) (*GroupResult, error) {
+	group := new(GroupResult)
+	group.ID = id
+
+	for i, sig := range sigs {
+		sig = sig.clone()
+		sig.Group = id
+		sig.GroupIndex = i
+		sig.GroupSize = len(sigs)
+		sig.Chord = chord
+
+		result, err := app.EnqueueContext(ctx, sig)
+		if err != nil {
+			// The group cannot complete, so its tasks are discarded rather
+			// than left to run on their own
+			for _, enqueued := range group.Results {
+				if err := app.Revoke(enqueued.ID, false); err != nil && err != ErrBroadcastUnsupported {
+					app.logger.Errorf("error revoking task %s of failed group: %v", enqueued.ID, err)
+				}
+			}
+			return nil, err
+		}
+		group.Results = append(group.Results, result)
+	}
+
+	return group, nil
+}
+
+// validateSignatures returns an error if any of the signatures is nil.
+func validateSignatures(op string, sigs []*Signature) error {
+	for i, sig := range sigs {
+		if sig == nil {
+			return fmt.Errorf("%s: signature %d is nil", op, i)
+		}
+	}
+	return nil
+}
+
+// taskPriority returns the default priority of the registered task.
+func (app *App) taskPriority(name string) uint8 {
+	if t, ok := app.taskMap.Load(name); ok {
+		return t.(*task).priority
+	}
+	return 0
+}
+
 func (app *App) queueForSignature(sig *Signature) string {
 	// TODO: proper routing
 	return app.defaultQueue
@@ -199,6 +739,12 @@ func (app *App) Binder() Binder {
 	return app.binder
 }
 
+// Concurrency returns the number of tasks the worker is configured to process
+// at once.
+func (app *App) Concurrency() int {
+	return app.concurrency
+}
+
 // SetLogger sets the logger that the app will use.
 func SetLogger(logger logrus.FieldLogger) OptionFunc {
 	return func(app *App) error {
@@ -228,6 +774,114 @@ func SetBinder(binder Binder) OptionFunc {
 	}
 }
 
+// Use adds middleware that wraps every registered task. The first middleware
+// is the outermost one.
+func Use(middleware ...MiddlewareFunc) OptionFunc {
+	return func(app *App) error {
+		app.middleware = append(app.middleware, middleware...)
+		return nil
+	}
+}
+
+// UseEnqueue adds interceptors that wrap every enqueue, after the publishing
+// is built and before it is sent to the broker. The first interceptor is the
+// outermost one.
+func UseEnqueue(interceptors ...EnqueueInterceptor) OptionFunc {
+	return func(app *App) error {
+		app.enqueueInterceptors = append(app.enqueueInterceptors, interceptors...)
+		return nil
+	}
+}
+
+// SetChordStore sets the store used to collect the results of chord headers.
+func SetChordStore(store ChordStore) OptionFunc {
+	return func(app *App) error {
+		app.chordStore = store
+		return nil
+	}
+}
+
+// SetHostname sets the name that identifies the worker in control commands.
+func SetHostname(hostname string) OptionFunc {
+	return func(app *App) error {
+		app.hostname = hostname
+		return nil
+	}
+}
+
+// SetControlExchange sets the exchange that control commands are broadcast
+// on. Use "celery.pidbox" to exchange commands with Celery workers.
+func SetControlExchange(exchange string) OptionFunc {
+	return func(app *App) error {
+		app.controlExchange = exchange
+		return nil
+	}
+}
+
+// SetSendEvents enables sending worker and task events, which can be consumed
+// with ConsumeEvents or Celery monitoring tools.
+func SetSendEvents(enabled bool) OptionFunc {
+	return func(app *App) error {
+		app.sendEvents = enabled
+		return nil
+	}
+}
+
+// AddEventListener adds a listener that is called in process for every event
+// of the worker, whether or not sending events is enabled.
+func AddEventListener(listener EventListener) OptionFunc {
+	return func(app *App) error {
+		app.eventListeners = append(app.eventListeners, listener)
+		return nil
+	}
+}
+
+// SetEventExchange sets the exchange that events are sent to. It defaults to
+// "celeryev", the exchange used by Celery.
+func SetEventExchange(exchange string) OptionFunc {
+	return func(app *App) error {
+		app.eventExchange = exchange
+		return nil
+	}
+}
+
+// SetHeartbeatInterval sets how often the worker sends heartbeat events.
+func SetHeartbeatInterval(interval time.Duration) OptionFunc {
+	return func(app *App) error {
+		if interval <= 0 {
+			return errors.New("worq.SetHeartbeatInterval: interval must be positive")
+		}
+		app.heartbeatInterval = interval
+		return nil
+	}
+}
+
+// SetTracerProvider sets the provider of the tracer used to trace enqueued and
+// executed tasks. It defaults to the global tracer provider.
+func SetTracerProvider(tp trace.TracerProvider) OptionFunc {
+	return func(app *App) error {
+		app.tracerProvider = tp
+		return nil
+	}
+}
+
+// SetPropagator sets how trace context is propagated in message headers. It
+// defaults to W3C trace context and baggage.
+func SetPropagator(propagator propagation.TextMapPropagator) OptionFunc {
+	return func(app *App) error {
+		app.propagator = propagator
+		return nil
+	}
+}
+
+// SetRevokeStore sets the store that keeps track of revoked tasks.
+func SetRevokeStore(store RevokeStore) OptionFunc {
+	return func(app *App) error {
+		app.revokeStore = store
+		return nil
+	}
+}
+
 func SetConcurrency(concurrency int) OptionFunc {
 	return func(app *App) error {
 		app.concurrency = concurrency
@@ -235,6 +889,17 @@ func SetConcurrency(concurrency int) OptionFunc {
 	}
 }
 
+// SetAcksLate sets whether messages are acknowledged after their task has
+// run, which is the default, or as soon as they are received. Acknowledging
+// late means a task is redelivered if the worker dies while running it, so
+// tasks should be idempotent.
+func SetAcksLate(acksLate bool) OptionFunc {
+	return func(app *App) error {
+		app.acksLate = acksLate
+		return nil
+	}
+}
+
 func SetDefaultQueue(queue string) OptionFunc {
 	return func(app *App) error {
 		app.defaultQueue = queue
@@ -0,0 +1,73 @@
+package worq
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp_handleMessage_acksEarly(t *testing.T) {
+	app, err := New(SetAcksLate(false))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var acked int
+	consumer := new(MockConsumer)
+	err = app.Register("tasks.fail", func(ctx Context) error {
+		acked = len(consumer.Acked())
+		return errors.New("failed")
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	msg := &MockMessage{MockID: "id", MockTask: "tasks.fail"}
+	assert.NoError(t, app.handleMessage(consumer, msg))
+
+	// The message is acknowledged before the task runs and not requeued when
+	// it fails
+	assert.Equal(t, 1, acked)
+	assert.Len(t, consumer.Acked(), 1)
+	assert.Empty(t, consumer.Requeued())
+}
+
+func TestApp_handleMessage_acksEarlyFailsForGood(t *testing.T) {
+	var events []string
+	broker := new(MockBroker)
+	app, err := New(
+		SetBroker(broker),
+		SetBinder(jsonBinder{}),
+		SetAcksLate(false),
+		AddEventListener(func(event Event, msg Message) {
+			events = append(events, event.Type())
+		}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, app.Register("tasks.fail", func(ctx Context) error {
+		return errors.New("failed")
+	}))
+	assert.NoError(t, app.Register("tasks.reject", func(ctx Context) error {
+		return ctx.Reject(true)
+	}))
+
+	// Failures cannot be retried once the message is acknowledged, so they
+	// enqueue errbacks
+	consumer := new(MockConsumer)
+	for _, task := range []string{"tasks.fail", "tasks.reject"} {
+		_, err := app.Enqueue(NewSignature(task, nil).LinkError(NewSignature("tasks.alert", nil)))
+		assert.NoError(t, err)
+		pubs := broker.Publishings()
+		assert.NoError(t, app.handleMessage(consumer, delivered(pubs[len(pubs)-1])))
+
+		pubs = broker.Publishings()
+		assert.Equal(t, "tasks.alert", decodeSignature(t, pubs[len(pubs)-1]).Task)
+	}
+	assert.Len(t, broker.Publishings(), 4)
+	assert.Contains(t, events, EventTaskFailed)
+	assert.NotContains(t, events, EventTaskRetried)
+}
@@ -0,0 +1,143 @@
+package worq
+
+import (
+	stdcontext "context"
+	"fmt"
+	"sync"
+)
+
+// BatchError is returned when some of a batch of publishings were not
+// confirmed by the broker. Errors has an entry for every publishing in the
+// batch, which is nil for those that succeeded.
+type BatchError struct {
+	Errors []error
+}
+
+// NewBatchError returns a *BatchError for errs, or nil if all of them are nil.
+func NewBatchError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return &BatchError{Errors: errs}
+		}
+	}
+	return nil
+}
+
+func (e *BatchError) Error() string {
+	var failed int
+	var first error
+	for _, err := range e.Errors {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	return fmt.Sprintf("worq: %d of %d publishings failed; first error: %v", failed, len(e.Errors), first)
+}
+
+// PendingResult is the result of a signature enqueued by EnqueueAsync, which
+// may not have been confirmed by the broker yet.
+type PendingResult struct {
+	*AsyncResult
+
+	confirm <-chan error
+	once    sync.Once
+	err     error
+}
+
+// Wait blocks until the broker has confirmed the publishing. It returns the
+// error if the publishing was rejected, in which case the task may not run
+// and should be enqueued again.
+func (r *PendingResult) Wait() error {
+	r.once.Do(func() {
+		if r.confirm != nil {
+			r.err = <-r.confirm
+		}
+	})
+	return r.err
+}
+
+// confirmKey is the context key under which EnqueueAsync asks the broker to
+// publish without waiting for confirmation.
+type confirmKey struct{}
+
+// confirmSlot receives the confirmation of a publishing made by an
+// AsyncBroker.
+type confirmSlot struct {
+	confirm <-chan error
+}
+
+// EnqueueAsync enqueues the signature without waiting for the broker to
+// confirm it, if the broker is an AsyncBroker. Call Wait on the result to
+// learn whether the publishing was confirmed.
+func (app *App) EnqueueAsync(ctx stdcontext.Context, sig *Signature) (*PendingResult, error) {
+	slot := new(confirmSlot)
+	result, key, err := app.enqueueSignature(stdcontext.WithValue(ctx, confirmKey{}, slot), sig)
+	if err != nil {
+		return nil, err
+	}
+
+	confirm := slot.confirm
+	if key != "" && confirm != nil {
+		// Release the unique key if the broker rejects the publishing, even
+		// if Wait is never called
+		pending := confirm
+		released := make(chan error, 1)
+		go func() {
+			err := <-pending
+			if err != nil {
+				app.unlockUnique(key, result.ID)
+			}
+			released <- err
+		}()
+		confirm = released
+	}
+
+	return &PendingResult{
+		AsyncResult: result,
+		confirm:     confirm,
+	}, nil
+}
+
+// EnqueueBatch enqueues the signatures, pipelining the publishings, and waits
+// until the broker has confirmed all of them. A result is returned for every
+// signature; if any failed, the error is a *BatchError reporting which.
+func (app *App) EnqueueBatch(ctx stdcontext.Context, sigs ...*Signature) ([]*AsyncResult, error) {
+	results := make([]*AsyncResult, len(sigs))
+	pending := make([]*PendingResult, len(sigs))
+	errs := make([]error, len(sigs))
+
+	for i, sig := range sigs {
+		pending[i], errs[i] = app.EnqueueAsync(ctx, sig)
+	}
+
+	for i, result := range pending {
+		if result == nil {
+			continue
+		}
+		results[i] = result.AsyncResult
+		errs[i] = result.Wait()
+	}
+
+	return results, NewBatchError(errs)
+}
+
+// publish sends the publishing to the broker. When called from EnqueueAsync
+// with an AsyncBroker, it returns once the publishing has been sent and
+// leaves its confirmation in the context.
+func (app *App) publish(ctx stdcontext.Context, pub *Publishing) error {
+	if slot, ok := ctx.Value(confirmKey{}).(*confirmSlot); ok {
+		if broker, ok := app.broker.(AsyncBroker); ok {
+			confirm, err := broker.EnqueueAsync(pub)
+			if err != nil {
+				return err
+			}
+			slot.confirm = confirm
+			return nil
+		}
+	}
+
+	return app.broker.Enqueue(pub)
+}
@@ -0,0 +1,50 @@
+package worq_test
+
+import (
+	stdcontext "context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	worq "github.com/jianyuan/go-worq"
+	"github.com/jianyuan/go-worq/protocols/celery"
+)
+
+func TestApp_EnqueueBatch(t *testing.T) {
+	errNacked := errors.New("nacked")
+
+	broker := &worq.MockBroker{
+		ConfirmError: func(pub *worq.Publishing) error {
+			if pub.Headers["task"] == "tasks.nacked" {
+				return errNacked
+			}
+			return nil
+		},
+	}
+	app, err := worq.New(
+		worq.SetBroker(broker),
+		worq.SetBinder(celery.NewBinder()),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	results, err := app.EnqueueBatch(stdcontext.Background(),
+		worq.NewSignature("tasks.add", nil),
+		worq.NewSignature("tasks.nacked", nil),
+		worq.NewSignature("tasks.add", nil),
+	)
+	if assert.IsType(t, &worq.BatchError{}, err) {
+		assert.Equal(t, []error{nil, errNacked, nil}, err.(*worq.BatchError).Errors)
+	}
+	if assert.Len(t, results, 3) {
+		assert.NotEmpty(t, results[1].ID)
+	}
+	assert.Len(t, broker.Publishings(), 3)
+
+	pending, err := app.EnqueueAsync(stdcontext.Background(), worq.NewSignature("tasks.add", nil))
+	if assert.NoError(t, err) {
+		assert.NoError(t, pending.Wait())
+	}
+}
@@ -3,4 +3,8 @@ package worq
 type Binder interface {
 	Bind(ctx Context, v interface{}) error
 	Unbind(ctx Context, id string, queue string, sig *Signature) (*Publishing, error)
+
+	// Signature decodes the signature of the message being processed,
+	// including the workflow attached to it.
+	Signature(ctx Context) (*Signature, error)
 }
@@ -7,3 +7,24 @@ type Broker interface {
 
 	Enqueue(*Publishing) error
 }
+
+// Broadcaster is implemented by brokers that can deliver a message to every
+// worker, such as for control commands.
+type Broadcaster interface {
+	// Broadcast publishes pub to every subscriber of exchange. The queue of
+	// the publishing is used as the routing key.
+	Broadcast(exchange string, pub *Publishing) error
+
+	// Subscribe returns a consumer receiving the messages broadcast to
+	// exchange with the routing key.
+	Subscribe(ctx Context, exchange, key string) (Consumer, error)
+}
+
+// AsyncBroker is implemented by brokers that can publish without waiting for
+// each publishing to be confirmed.
+type AsyncBroker interface {
+	// EnqueueAsync publishes pub and returns a channel that receives exactly
+	// one value: nil once the broker has confirmed the publishing, or the
+	// error if it was rejected.
+	EnqueueAsync(pub *Publishing) (<-chan error, error)
+}
@@ -0,0 +1,63 @@
+package worq
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	_ Broker      = (*MockBroker)(nil)
+	_ AsyncBroker = (*MockBroker)(nil)
+)
+
+// MockBroker is a Broker that records the publishings enqueued to it.
+type MockBroker struct {
+	mu          sync.Mutex
+	publishings []*Publishing
+
+	EnqueueError error
+
+	// ConfirmError, if set, returns the error that EnqueueAsync confirms
+	// the publishing with.
+	ConfirmError func(*Publishing) error
+}
+
+func (b *MockBroker) Consume(ctx Context, queueName string) (Consumer, error) {
+	return nil, errors.New("worq: MockBroker cannot consume")
+}
+
+func (b *MockBroker) Close() error {
+	return nil
+}
+
+func (b *MockBroker) Enqueue(pub *Publishing) error {
+	if b.EnqueueError != nil {
+		return b.EnqueueError
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.publishings = append(b.publishings, pub)
+	return nil
+}
+
+// Publishings returns the publishings enqueued so far.
+func (b *MockBroker) Publishings() []*Publishing {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*Publishing(nil), b.publishings...)
+}
+
+func (b *MockBroker) EnqueueAsync(pub *Publishing) (<-chan error, error) {
+	if err := b.Enqueue(pub); err != nil {
+		return nil, err
+	}
+
+	confirm := make(chan error, 1)
+	if b.ConfirmError != nil {
+		confirm <- b.ConfirmError(pub)
+	} else {
+		confirm <- nil
+	}
+	return confirm, nil
+}
@@ -3,31 +3,104 @@ package amqpbroker
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	uuid "github.com/gofrs/uuid"
 	worq "github.com/jianyuan/go-worq"
+	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
 )
 
+// ErrNotAcknowledged is returned when the broker does not acknowledge a
+// publishing.
+var ErrNotAcknowledged = errors.New("amqpbroker.Enqueue: Failed to receive acknowledgement from broker")
+
+// Observer receives instrumentation callbacks from the broker. It is
+// implemented by metrics.Collector.
+type Observer interface {
+	// EnqueueObserved is called after every Enqueue with the time it took
+	// for the publishing to be confirmed, and the error if any.
+	EnqueueObserved(queue string, d time.Duration, err error)
+
+	// ConfirmFailed is called when the broker does not acknowledge a
+	// publishing.
+	ConfirmFailed(queue string)
+}
+
 // OptionFunc is a function that configures the AMQPBroker.
 type OptionFunc func(*Broker) error
 type ConnectionFactory func() (*amqp.Connection, error)
 
-var _ worq.Broker = (*Broker)(nil)
+var (
+	_ worq.Broker      = (*Broker)(nil)
+	_ worq.AsyncBroker = (*Broker)(nil)
+)
+
+// Exchange describes an AMQP exchange.
+type Exchange struct {
+	Name       string
+	Kind       string
+	Durable    bool
+	AutoDelete bool
+	Args       amqp.Table
+}
 
 type Broker struct {
 	exchange     string
 	exchangeType string
 
-	connFactory ConnectionFactory
+	// exchanges and queues are declared whenever the broker connects
+	exchanges  []*Exchange
+	queues     map[string]*Queue
+	queueOrder []string
 
-	conn *amqp.Connection
+	// defaultQueueArgs are the arguments of queues that have not been
+	// declared with DeclareQueue
+	defaultQueueArgs amqp.Table
+	offsetStore      OffsetStore
 
-	// TODO: Extract these into a session struct
-	ch      *amqp.Channel
-	confirm chan amqp.Confirmation
+	// broadcastExchanges describes the exchanges used by Broadcast and
+	// Subscribe. Unknown exchanges are declared as transient fanouts.
+	broadcastExchanges map[string]*Exchange
+
+	connFactory ConnectionFactory
+	observer    Observer
+	logger      logrus.FieldLogger
+
+	// minBackoff and maxBackoff bound the delay between reconnection
+	// attempts.
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// publishChannels is the number of channels used for publishing
+	publishChannels int
+
+	// mandatory makes Enqueue fail for messages that cannot be routed to a
+	// queue. declareMissingQueues declares the queue of every publishing
+	// before it is first published to on a connection.
+	mandatory            bool
+	declareMissingQueues bool
+
+	// prefetch is the number of unacknowledged messages delivered to each
+	// consumer, or -1 to derive it from the concurrency of the App.
+	// queuePrefetch overrides it for specific queues.
+	prefetch           int
+	prefetchMultiplier int
+	queuePrefetch      map[string]int
+
+	mu          sync.Mutex
+	closed      bool
+	conn        *amqp.Connection
+	sessions    []*session
+	nextSession int
+	declared    map[string]bool
+
+	// declaredExchanges holds the broadcast exchanges declared on the
+	// current connection
+	declaredExchanges map[string]bool
+	consumers         map[*Consumer]struct{}
 }
 
 func New(connectionFactory ConnectionFactory, options ...OptionFunc) (*Broker, error) {
@@ -35,7 +108,30 @@ func New(connectionFactory ConnectionFactory, options ...OptionFunc) (*Broker, e
 		exchange:     "go-worq",
 		exchangeType: "direct",
 		connFactory:  connectionFactory,
-		confirm:      make(chan amqp.Confirmation, 1),
+		minBackoff:   500 * time.Millisecond,
+		maxBackoff:   30 * time.Second,
+
+		queues:           make(map[string]*Queue),
+		defaultQueueArgs: make(amqp.Table),
+
+		publishChannels: 4,
+		mandatory:       true,
+
+		prefetch:           -1,
+		prefetchMultiplier: 1,
+		queuePrefetch:      make(map[string]int),
+
+		consumers: make(map[*Consumer]struct{}),
+
+		broadcastExchanges: map[string]*Exchange{
+			// Celery's remote control exchanges
+			"celery.pidbox":       {Name: "celery.pidbox", Kind: "fanout", AutoDelete: true},
+			"reply.celery.pidbox": {Name: "reply.celery.pidbox", Kind: "direct", AutoDelete: true},
+			"reply.worq.pidbox":   {Name: "reply.worq.pidbox", Kind: "direct", AutoDelete: true},
+
+			// Celery's event exchange
+			"celeryev": {Name: "celeryev", Kind: "topic", Durable: true},
+		},
 	}
 
 	for _, option := range options {
@@ -55,303 +151,424 @@ func SetExchange(exchange, exchangeType string) OptionFunc {
 	}
 }
 
-func (b *Broker) getConn() (*amqp.Connection, error) {
-	if b.conn == nil {
-		var err error
-		b.conn, err = b.connFactory()
-		if err != nil {
-			return nil, err
-		}
+// SetBroadcastExchange sets how the exchange named ex.Name is declared when
+// used by Broadcast and Subscribe.
+func SetBroadcastExchange(ex Exchange) OptionFunc {
+	return func(b *Broker) error {
+		b.broadcastExchanges[ex.Name] = &ex
+		return nil
 	}
-	return b.conn, nil
 }
 
-func (b *Broker) getChannel() (*amqp.Channel, error) {
-	if b.ch == nil {
-		var conn *amqp.Connection
-		var err error
-		conn, err = b.getConn()
-		if err != nil {
-			return nil, err
-		}
+// SetObserver sets the observer notified of enqueued messages.
+func SetObserver(observer Observer) OptionFunc {
+	return func(b *Broker) error {
+		b.observer = observer
+		return nil
+	}
+}
 
-		b.ch, err = conn.Channel()
-		if err != nil {
-			return nil, err
-		}
+// SetLogger sets the logger that connection state changes are logged to. It
+// defaults to the logger of the App that first consumes from the broker.
+func SetLogger(logger logrus.FieldLogger) OptionFunc {
+	return func(b *Broker) error {
+		b.logger = logger
+		return nil
+	}
+}
 
-		// Put this channel into confirm mode
-		err = b.ch.Confirm(false)
-		if err != nil {
-			// publisher confirms not supported
-			// TODO: logging
-			close(b.confirm)
-		} else {
-			b.ch.NotifyPublish(b.confirm)
+// SetReconnectBackoff sets the delay between reconnection attempts, which
+// doubles from min up to max.
+func SetReconnectBackoff(min, max time.Duration) OptionFunc {
+	return func(b *Broker) error {
+		if min <= 0 || max < min {
+			return errors.New("amqpbroker.SetReconnectBackoff: invalid backoff")
 		}
+		b.minBackoff = min
+		b.maxBackoff = max
+		return nil
 	}
-	return b.ch, nil
 }
 
-func (b *Broker) Consume(ctx worq.Context, queueName string) (worq.Consumer, error) {
-	var err error
-
-	ch, err := b.getChannel()
-	if err != nil {
-		return nil, err
+// SetPublishChannels sets the number of channels that publishings are spread
+// over.
+func SetPublishChannels(n int) OptionFunc {
+	return func(b *Broker) error {
+		if n < 1 {
+			return errors.New("amqpbroker.SetPublishChannels: n must be positive")
+		}
+		b.publishChannels = n
+		return nil
 	}
+}
 
-	if err := ch.ExchangeDeclare(
-		b.exchange,     // name
-		b.exchangeType, // kind
-		true,           // durable
-		false,          // autoDelete
-		false,          // internal
-		false,          // noWait
-		nil,            // args
-	); err != nil {
-		return nil, err
+// SetMandatory sets whether Enqueue publishes messages as mandatory, which
+// is the default, so that it returns an *UnroutableError for messages that
+// cannot be routed to any queue instead of them being dropped.
+func SetMandatory(mandatory bool) OptionFunc {
+	return func(b *Broker) error {
+		b.mandatory = mandatory
+		return nil
 	}
+}
 
-	queue, err := ch.QueueDeclare(
-		queueName, // name
-		true,      // durable,
-		false,     // autoDelete
-		false,     // exclusive
-		false,     // noWait
-		nil,       // args
-	)
-	if err != nil {
-		return nil, err
+// SetDeclareMissingQueues sets whether Enqueue declares the queue of a
+// publishing, as Consume would, before publishing to it.
+func SetDeclareMissingQueues(declare bool) OptionFunc {
+	return func(b *Broker) error {
+		b.declareMissingQueues = declare
+		return nil
 	}
+}
 
-	if err := ch.QueueBind(
-		queue.Name, // name
-		queue.Name, // key
-		b.exchange, // exchange
-		false,      // noWait
-		nil,        // args
-	); err != nil {
-		return nil, err
+// SetPrefetch sets the number of unacknowledged messages delivered to each
+// consumer. A count of 0 means no limit, except for streams, which RabbitMQ
+// requires a limit for, so they use the concurrency of the App instead. By
+// default, the count is the concurrency of the App times the prefetch
+// multiplier.
+func SetPrefetch(count int) OptionFunc {
+	return func(b *Broker) error {
+		if count < 0 {
+			return errors.New("amqpbroker.SetPrefetch: count must not be negative")
+		}
+		b.prefetch = count
+		return nil
 	}
+}
 
-	// TODO: customisation
-	ctag := fmt.Sprintf("worq-%s", uuid.Must(uuid.NewV4()))
-
-	// TODO: prefetch using ch.Qos()
+// SetPrefetchMultiplier sets the number of messages prefetched per task the
+// App processes at once, like Celery's worker_prefetch_multiplier. A
+// multiplier of 0 means no limit. It defaults to 1.
+func SetPrefetchMultiplier(multiplier int) OptionFunc {
+	return func(b *Broker) error {
+		if multiplier < 0 {
+			return errors.New("amqpbroker.SetPrefetchMultiplier: multiplier must not be negative")
+		}
+		b.prefetchMultiplier = multiplier
+		return nil
+	}
+}
 
-	deliveries, err := ch.Consume(
-		queue.Name, // queue
-		ctag,       // tag
-		false,      // autoAck
-		false,      // exclusive
-		false,      // noLocal
-		false,      // noWait
-		nil,        // args
-	)
-	if err != nil {
-		return nil, err
+// SetQueuePrefetch sets the number of unacknowledged messages delivered to
+// consumers of the queue, overriding SetPrefetch.
+func SetQueuePrefetch(queue string, count int) OptionFunc {
+	return func(b *Broker) error {
+		if count < 0 {
+			return errors.New("amqpbroker.SetQueuePrefetch: count must not be negative")
+		}
+		b.queuePrefetch[queue] = count
+		return nil
 	}
+}
 
-	consumer := &Consumer{
-		app:        ctx.App(),
-		deliveries: deliveries,
-		cancel: func() error {
-			if ch != nil {
-				return ch.Cancel(
-					ctag, // consumer
-					true, // noWait
-				)
-			}
-			return nil
-		},
+// prefetchCount returns the prefetch count for consumers of the queue.
+func (b *Broker) prefetchCount(app *worq.App, queue string, stream bool) int {
+	count := app.Concurrency() * b.prefetchMultiplier
+	if c, ok := b.queuePrefetch[queue]; ok {
+		count = c
+	} else if b.prefetch >= 0 {
+		count = b.prefetch
 	}
 
-	// TODO: keep track of consumers
+	// RabbitMQ refuses to consume from a stream without a prefetch limit
+	if stream && count == 0 {
+		count = app.Concurrency()
+		if count < 1 {
+			count = 1
+		}
+	}
+	return count
+}
 
-	return consumer, nil
+func (b *Broker) log() logrus.FieldLogger {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.logLocked()
 }
 
-func (b *Broker) Close() error {
-	// TODO: cancel all active consumers
-	if b.conn != nil {
-		return b.conn.Close()
+func (b *Broker) logLocked() logrus.FieldLogger {
+	if b.logger == nil {
+		return logrus.StandardLogger()
 	}
-	return nil
+	return b.logger
 }
 
-func (b *Broker) Enqueue(pub *worq.Publishing) error {
-	if pub == nil {
-		return errors.New("amqpbroker: Enqueue(nil)")
+// useAppLogger logs to the logger of the App if no logger has been set.
+func (b *Broker) useAppLogger(ctx worq.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.logger == nil {
+		b.logger = ctx.Logger()
 	}
+}
+
+// Consume consumes from the queue, declaring it first. Queues that have not
+// been given to DeclareQueue are durable and bound to the exchange of the
+// broker using their name as the routing key.
+func (b *Broker) Consume(ctx worq.Context, queueName string) (worq.Consumer, error) {
+	queue := b.queue(queueName)
+	return b.consume(ctx, queue, func(ch *amqp.Channel) (string, error) {
+		if err := b.declareExchange(ch); err != nil {
+			return "", err
+		}
+		return b.declareQueueTopology(ch, queue)
+	})
+}
 
-	var err error
+func (b *Broker) consume(ctx worq.Context, queue *Queue, setup func(ch *amqp.Channel) (string, error)) (worq.Consumer, error) {
+	b.useAppLogger(ctx)
 
-	ch, err := b.getChannel()
-	if err != nil {
-		return err
+	consumer := &Consumer{
+		app:    ctx.App(),
+		broker: b,
+		// TODO: customisation
+		ctag:       fmt.Sprintf("worq-%s", uuid.Must(uuid.NewV4())),
+		queue:      queue,
+		setup:      setup,
+		deliveries: make(chan amqp.Delivery),
+		done:       make(chan struct{}),
 	}
 
-	// TODO: Retries
-	err = ch.Publish(
-		b.exchange, // exchange
-		pub.Queue,  // key
-		false,      // mandatory
-		false,      // immediate,
-		amqp.Publishing{
-			Headers:      pub.Headers,
-			ContentType:  pub.ContentType,
-			DeliveryMode: amqp.Persistent,
-			Timestamp:    time.Now(),
-			Body:         pub.Body,
-		},
-	)
+	deliveries, err := consumer.subscribe()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if confirmed, ok := <-b.confirm; ok && !confirmed.Ack {
-		return errors.New("amqpbroker.Enqueue: Failed to receive acknowledgement from broker")
-	}
+	b.mu.Lock()
+	b.consumers[consumer] = struct{}{}
+	b.mu.Unlock()
 
-	// TODO: return publishing
-	return nil
-}
+	go consumer.forward(deliveries)
 
-var _ worq.Consumer = (*Consumer)(nil)
+	return consumer, nil
+}
 
-type Consumer struct {
-	app *worq.App
+func (b *Broker) removeConsumer(c *Consumer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.consumers, c)
+}
 
-	deliveries <-chan amqp.Delivery
-	cancel     func() error
+// Close cancels all active consumers and closes the connection.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	conn := b.conn
+	consumers := make([]*Consumer, 0, len(b.consumers))
+	for c := range b.consumers {
+		consumers = append(consumers, c)
+	}
+	b.mu.Unlock()
 
-	closemu sync.RWMutex
-	closed  bool
+	for _, c := range consumers {
+		if err := c.Close(); err != nil {
+			b.log().Warnf("amqpbroker: error cancelling consumer %s: %v", c.ctag, err)
+		}
+	}
 
-	message *Message
-	lasterr error
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
 }
 
-func (c *Consumer) Next() bool {
-	doClose, ok := c.next()
-	if doClose {
-		c.Close()
+func (b *Broker) Enqueue(pub *worq.Publishing) error {
+	confirm, err := b.EnqueueAsync(pub)
+	if err != nil {
+		return err
 	}
-	return ok
+	return <-confirm
 }
 
-func (c *Consumer) next() (doClose, ok bool) {
-	c.closemu.RLock()
-	defer c.closemu.RUnlock()
-
-	if c.closed {
-		return false, false
+// EnqueueAsync publishes pub without waiting for the broker to confirm it.
+// The returned channel receives nil once the publishing is confirmed,
+// ErrNotAcknowledged if it was not, or an *UnroutableError if no queue is
+// bound to its routing key.
+func (b *Broker) EnqueueAsync(pub *worq.Publishing) (<-chan error, error) {
+	if pub == nil {
+		return nil, errors.New("amqpbroker: Enqueue(nil)")
 	}
 
-	delivery, isOpen := <-c.deliveries
-	if !isOpen {
-		return true, false
+	start := time.Now()
+	confirm := make(chan error, 1)
+
+	// TODO: return publishing
+	if b.declareMissingQueues {
+		if err := b.ensureQueue(pub.Queue); err != nil {
+			b.observeEnqueue(pub.Queue, start, err)
+			return nil, err
+		}
 	}
 
-	c.message = &Message{
-		app:      c.app,
-		delivery: &delivery,
+	err := b.publish(b.exchange, pub, b.mandatory, func(err error) {
+		b.observeEnqueue(pub.Queue, start, err)
+		confirm <- err
+	})
+	if err != nil {
+		b.observeEnqueue(pub.Queue, start, err)
+		return nil, err
 	}
-	return false, true
-}
 
-func (c *Consumer) Err() error {
-	return c.lasterr
+	return confirm, nil
 }
 
-func (c *Consumer) Message() (worq.Message, error) {
-	c.closemu.RLock()
-	defer c.closemu.RUnlock()
+// EnqueueBatch publishes pubs and then waits for the broker to confirm all of
+// them. If any publishing fails, a *worq.BatchError reports which.
+func (b *Broker) EnqueueBatch(pubs []*worq.Publishing) error {
+	confirms := make([]<-chan error, len(pubs))
+	errs := make([]error, len(pubs))
 
-	if c.closed {
-		return nil, errors.New("amqp: consumer is closed")
+	for i, pub := range pubs {
+		confirms[i], errs[i] = b.EnqueueAsync(pub)
 	}
 
-	if c.message == nil {
-		return nil, errors.New("amqp: Message called without calling Next")
+	for i, confirm := range confirms {
+		if confirm != nil {
+			errs[i] = <-confirm
+		}
 	}
 
-	return c.message, nil
+	return worq.NewBatchError(errs)
 }
 
-func (c *Consumer) Close() error {
-	return c.close(nil)
+func (b *Broker) observeEnqueue(queue string, start time.Time, err error) {
+	if b.observer == nil {
+		return
+	}
+	if err == ErrNotAcknowledged {
+		b.observer.ConfirmFailed(queue)
+	}
+	b.observer.EnqueueObserved(queue, time.Since(start), err)
 }
 
-func (c *Consumer) close(err error) error {
-	c.closemu.Lock()
-	defer c.closemu.Unlock()
-
-	if c.closed {
-		return nil
+func (b *Broker) Broadcast(exchange string, pub *worq.Publishing) error {
+	if pub == nil {
+		return errors.New("amqpbroker: Broadcast(nil)")
 	}
-	c.closed = true
 
-	if c.lasterr == nil {
-		c.lasterr = err
+	if err := b.ensureBroadcastExchange(exchange); err != nil {
+		return err
 	}
 
-	if c.cancel != nil {
-		return c.cancel()
+	confirm := make(chan error, 1)
+	// Broadcasts are not mandatory, as there may be no subscribers
+	if err := b.publish(exchange, pub, false, func(err error) { confirm <- err }); err != nil {
+		return err
 	}
-
-	return nil
+	return <-confirm
 }
 
-func (c *Consumer) Ack(msg worq.Message) error {
-	return msg.(*Message).delivery.Ack(
-		false, // multiple
-	)
+func (b *Broker) Subscribe(ctx worq.Context, exchange, key string) (worq.Consumer, error) {
+	return b.consume(ctx, nil, func(ch *amqp.Channel) (string, error) {
+		if err := b.declareBroadcastExchange(ch, exchange); err != nil {
+			return "", err
+		}
+
+		queue, err := ch.QueueDeclare(
+			"",    // name
+			false, // durable,
+			true,  // autoDelete
+			true,  // exclusive
+			false, // noWait
+			nil,   // args
+		)
+		if err != nil {
+			return "", err
+		}
+
+		if err := ch.QueueBind(
+			queue.Name, // name
+			key,        // key
+			exchange,   // exchange
+			false,      // noWait
+			nil,        // args
+		); err != nil {
+			return "", err
+		}
+
+		return queue.Name, nil
+	})
 }
 
-func (c *Consumer) Nack(msg worq.Message, requeue bool) error {
-	return msg.(*Message).delivery.Nack(
-		false,   // multiple
-		requeue, // requeue
+func (b *Broker) declareBroadcastExchange(ch *amqp.Channel, exchange string) error {
+	ex, ok := b.broadcastExchanges[exchange]
+	if !ok {
+		ex = &Exchange{Name: exchange, Kind: "fanout", AutoDelete: true}
+	}
+
+	return ch.ExchangeDeclare(
+		ex.Name,       // name
+		ex.Kind,       // kind
+		ex.Durable,    // durable
+		ex.AutoDelete, // autoDelete
+		false,         // internal
+		false,         // noWait
+		ex.Args,       // args
 	)
 }
 
-var _ worq.Message = (*Message)(nil)
+// publish sends pub to the exchange and calls confirmed once the broker has
+// confirmed it.
+func (b *Broker) publish(exchange string, pub *worq.Publishing, mandatory bool, confirmed func(error)) error {
+	s, err := b.getSession()
+	if err != nil {
+		return err
+	}
 
-type Message struct {
-	app      *worq.App
-	delivery *amqp.Delivery
+	// TODO: Retries
+	return s.publish(
+		exchange,  // exchange
+		pub.Queue, // key
+		mandatory, // mandatory
+		publishing(pub),
+		confirmed,
+	)
 }
 
-func (msg *Message) Queue() string {
-	return msg.delivery.RoutingKey
-}
+// publishedAtHeader holds the publish time in milliseconds since the epoch.
+const publishedAtHeader = "x-worq-published-at"
+
+// publishing maps pub to an AMQP publishing.
+func publishing(pub *worq.Publishing) amqp.Publishing {
+	msg := amqp.Publishing{
+		Headers:         pub.Headers,
+		ContentType:     pub.ContentType,
+		ContentEncoding: pub.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		Priority:        pub.Priority,
+		CorrelationId:   pub.CorrelationID,
+		ReplyTo:         pub.ReplyTo,
+		MessageId:       pub.ID,
+		Timestamp:       pub.Timestamp,
+		Body:            pub.Body,
+	}
 
-func (msg *Message) ID() string {
-	id, err := msg.app.Protocol().ID(msg)
-	_ = err // TODO
-	return id
-}
+	if pub.Transient {
+		msg.DeliveryMode = amqp.Transient
+	}
 
-func (msg *Message) Task() string {
-	task, err := msg.app.Protocol().Task(msg)
-	_ = err // TODO
-	return task
-}
+	if pub.Expiration > 0 {
+		// The expiration is in milliseconds, where 0 would expire the
+		// message unless it can be delivered immediately
+		ms := int64(pub.Expiration / time.Millisecond)
+		if ms < 1 {
+			ms = 1
+		}
+		msg.Expiration = strconv.FormatInt(ms, 10)
+	}
 
-func (msg *Message) Headers() map[string]interface{} {
-	m := make(map[string]interface{}, len(msg.delivery.Headers))
-	for k, v := range msg.delivery.Headers {
-		m[k] = v
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
 	}
-	return m
-}
 
-func (msg *Message) ContentType() string {
-	return msg.delivery.ContentType
-}
+	// The AMQP timestamp only has second precision, so the publish time is
+	// also sent in milliseconds to measure queue latency
+	headers := make(amqp.Table, len(pub.Headers)+1)
+	for k, v := range pub.Headers {
+		headers[k] = v
+	}
+	headers[publishedAtHeader] = msg.Timestamp.UnixNano() / int64(time.Millisecond)
+	msg.Headers = headers
 
-func (msg *Message) Body() []byte {
-	return msg.delivery.Body
+	return msg
 }
@@ -0,0 +1,63 @@
+package amqpbroker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+
+	worq "github.com/jianyuan/go-worq"
+)
+
+func TestPublishing(t *testing.T) {
+	msg := publishing(&worq.Publishing{ID: "task-id"})
+	assert.Equal(t, amqp.Persistent, msg.DeliveryMode)
+	assert.Equal(t, "task-id", msg.MessageId)
+	assert.Empty(t, msg.Expiration)
+	assert.False(t, msg.Timestamp.IsZero())
+
+	msg = publishing(&worq.Publishing{
+		Transient:     true,
+		Expiration:    90 * time.Second,
+		CorrelationID: "correlation-id",
+		ReplyTo:       "replies",
+	})
+	assert.Equal(t, amqp.Transient, msg.DeliveryMode)
+	assert.Equal(t, "90000", msg.Expiration)
+	assert.Equal(t, "correlation-id", msg.CorrelationId)
+	assert.Equal(t, "replies", msg.ReplyTo)
+}
+
+func TestPublishingTimestamp(t *testing.T) {
+	published := time.Date(2020, 1, 2, 3, 4, 5, 678000000, time.UTC)
+	headers := map[string]interface{}{"task": "tasks.add"}
+	msg := publishing(&worq.Publishing{Headers: headers, Timestamp: published})
+	assert.NotContains(t, headers, publishedAtHeader)
+	assert.Equal(t, "tasks.add", msg.Headers["task"])
+
+	// The AMQP timestamp is truncated to seconds on the wire
+	delivery := &Message{delivery: &amqp.Delivery{
+		Headers:   msg.Headers,
+		Timestamp: published.Truncate(time.Second),
+	}}
+	assert.True(t, published.Equal(delivery.Timestamp()))
+
+	delivery = &Message{delivery: &amqp.Delivery{Timestamp: published}}
+	assert.True(t, published.Equal(delivery.Timestamp()))
+}
+
+func TestRepublishing(t *testing.T) {
+	msg := republishing(&amqp.Delivery{
+		Headers:      amqp.Table{"task": "tasks.add", "x-delivery-count": int64(2)},
+		DeliveryMode: amqp.Persistent,
+		Priority:     5,
+		MessageId:    "task-id",
+		Body:         []byte("[]"),
+	})
+	assert.Equal(t, amqp.Table{"task": "tasks.add"}, msg.Headers)
+	assert.Equal(t, amqp.Persistent, msg.DeliveryMode)
+	assert.Equal(t, uint8(5), msg.Priority)
+	assert.Equal(t, "task-id", msg.MessageId)
+	assert.Equal(t, []byte("[]"), msg.Body)
+}
@@ -0,0 +1,120 @@
+package amqpbroker
+
+import (
+	"errors"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrClosed is returned when the broker has been closed.
+var ErrClosed = errors.New("amqpbroker: broker is closed")
+
+func (b *Broker) getConn() (*amqp.Connection, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.getConnLocked()
+}
+
+func (b *Broker) getConnLocked() (*amqp.Connection, error) {
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	if b.conn == nil {
+		conn, err := b.connFactory()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := b.declareTopology(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		b.conn = conn
+
+		go b.watchConn(conn, conn.NotifyClose(make(chan *amqp.Error, 1)))
+		b.logLocked().Info("amqpbroker: connected")
+	}
+	return b.conn, nil
+}
+
+// getSession returns one of the publishing sessions, in turn, opening a new
+// one if it is missing or closed.
+func (b *Broker) getSession() (*session, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	conn, err := b.getConnLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b.sessions) != b.publishChannels {
+		b.sessions = make([]*session, b.publishChannels)
+	}
+
+	i := b.nextSession % len(b.sessions)
+	b.nextSession++
+
+	if b.sessions[i] == nil || b.sessions[i].isClosed() {
+		b.sessions[i], err = newSession(conn, b.logLocked())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.sessions[i], nil
+}
+
+// watchConn reconnects when the connection is closed by an error.
+func (b *Broker) watchConn(conn *amqp.Connection, notify chan *amqp.Error) {
+	amqpErr, ok := <-notify
+
+	b.mu.Lock()
+	if b.conn == conn {
+		b.conn = nil
+		b.sessions = nil
+		b.declared = nil
+		b.declaredExchanges = nil
+	}
+	closed := b.closed
+	b.mu.Unlock()
+
+	if !ok || amqpErr == nil || closed {
+		b.log().Info("amqpbroker: connection closed")
+		return
+	}
+
+	b.log().Errorf("amqpbroker: connection lost: %v", amqpErr)
+	b.reconnect()
+}
+
+// reconnect dials the broker with exponential backoff until it succeeds or
+// the broker is closed. Consumers re-establish themselves on the new
+// connection.
+func (b *Broker) reconnect() {
+	backoff := b.minBackoff
+	for {
+		_, err := b.getConn()
+		if err == nil {
+			b.log().Info("amqpbroker: reconnected")
+			return
+		}
+
+		if err == ErrClosed {
+			return
+		}
+
+		b.log().Warnf("amqpbroker: reconnect failed, retrying in %s: %v", backoff, err)
+		time.Sleep(backoff)
+		backoff = b.nextBackoff(backoff)
+	}
+}
+
+func (b *Broker) nextBackoff(d time.Duration) time.Duration {
+	d *= 2
+	if d > b.maxBackoff {
+		d = b.maxBackoff
+	}
+	return d
+}
@@ -0,0 +1,424 @@
+package amqpbroker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	worq "github.com/jianyuan/go-worq"
+	"github.com/streadway/amqp"
+)
+
+var (
+	_ worq.Consumer         = (*Consumer)(nil)
+	_ worq.DelayingConsumer = (*Consumer)(nil)
+)
+
+type Consumer struct {
+	app    *worq.App
+	broker *Broker
+	ctag   string
+
+	// queue is the declaration of the queue consumed from, or nil for
+	// subscriptions to broadcasts.
+	queue *Queue
+
+	// setup declares the topology needed by the consumer on the channel and
+	// returns the name of the queue to consume from. It is called again
+	// whenever the consumer is re-established.
+	setup func(ch *amqp.Channel) (string, error)
+
+	// deliveries receives the deliveries of every channel the consumer is
+	// subscribed on, so it survives reconnections.
+	deliveries chan amqp.Delivery
+	done       chan struct{}
+
+	closemu sync.RWMutex
+	closed  bool
+	ch      *amqp.Channel
+
+	// unacked is the number of messages returned by Next that have not been
+	// acknowledged yet. The channel is kept open after the consumer is closed
+	// until they are.
+	unacked int
+
+	message *Message
+	lasterr error
+
+	// fetched is whether the message returned by Next has been fetched by
+	// Message.
+	fetched bool
+}
+
+// subscribe opens a channel for the consumer and starts consuming on it.
+func (c *Consumer) subscribe() (<-chan amqp.Delivery, error) {
+	conn, err := c.broker.getConn()
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := c.consumeOn(ch)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	c.closemu.Lock()
+	defer c.closemu.Unlock()
+
+	// The consumer may have been closed while subscribing
+	if c.closed {
+		ch.Cancel(c.ctag, true)
+		return nil, ch.Close()
+	}
+	c.ch = ch
+
+	return deliveries, nil
+}
+
+func (c *Consumer) consumeOn(ch *amqp.Channel) (<-chan amqp.Delivery, error) {
+	queueName, err := c.setup(ch)
+	if err != nil {
+		return nil, err
+	}
+
+	// Limit the unacknowledged messages delivered to this consumer, so that
+	// the queue is shared among workers
+	prefetch := c.broker.prefetchCount(c.app, queueName, c.queue != nil && c.queue.isStream())
+	if err := ch.Qos(
+		prefetch, // prefetchCount
+		0,        // prefetchSize
+		false,    // global
+	); err != nil {
+		return nil, err
+	}
+
+	var args amqp.Table
+	if c.queue != nil {
+		if args, err = c.broker.consumeArgs(c.queue); err != nil {
+			return nil, err
+		}
+	}
+
+	return ch.Consume(
+		queueName, // queue
+		c.ctag,    // tag
+		false,     // autoAck
+		false,     // exclusive
+		false,     // noLocal
+		false,     // noWait
+		args,      // args
+	)
+}
+
+// forward passes deliveries on until the consumer is closed, resubscribing
+// whenever the channel or connection is lost.
+func (c *Consumer) forward(deliveries <-chan amqp.Delivery) {
+	defer close(c.deliveries)
+
+	for {
+		for delivery := range deliveries {
+			select {
+			case c.deliveries <- delivery:
+			case <-c.done:
+				delivery.Nack(false, true)
+				return
+			}
+		}
+
+		if c.isClosed() {
+			return
+		}
+
+		// The channel may still be open if only the consumer was cancelled,
+		// such as when its queue is deleted
+		c.closemu.Lock()
+		if c.ch != nil {
+			c.ch.Close()
+			c.ch = nil
+		}
+		c.closemu.Unlock()
+
+		c.broker.log().Warnf("amqpbroker: consumer %s interrupted, resubscribing", c.ctag)
+
+		backoff := c.broker.minBackoff
+		for {
+			var err error
+			deliveries, err = c.subscribe()
+			if err == nil && deliveries == nil {
+				return
+			}
+			if err == nil {
+				c.broker.log().Infof("amqpbroker: consumer %s resubscribed", c.ctag)
+				break
+			}
+
+			if err == ErrClosed {
+				c.setErr(err)
+				return
+			}
+
+			if c.isClosed() {
+				return
+			}
+
+			c.broker.log().Warnf("amqpbroker: consumer %s failed to resubscribe, retrying in %s: %v", c.ctag, backoff, err)
+
+			select {
+			case <-time.After(backoff):
+			case <-c.done:
+				return
+			}
+			backoff = c.broker.nextBackoff(backoff)
+		}
+	}
+}
+
+func (c *Consumer) isClosed() bool {
+	c.closemu.RLock()
+	defer c.closemu.RUnlock()
+	return c.closed
+}
+
+func (c *Consumer) setErr(err error) {
+	c.closemu.Lock()
+	defer c.closemu.Unlock()
+	if c.lasterr == nil {
+		c.lasterr = err
+	}
+}
+
+func (c *Consumer) Next() bool {
+	doClose, ok := c.next()
+	if doClose {
+		c.Close()
+	}
+	return ok
+}
+
+func (c *Consumer) next() (doClose, ok bool) {
+	c.closemu.RLock()
+	closed := c.closed
+	c.closemu.RUnlock()
+
+	if closed {
+		return false, false
+	}
+
+	// Wait for a delivery without holding the lock, so that Close can cancel
+	// the consumer and close the deliveries channel
+	delivery, isOpen := <-c.deliveries
+	if !isOpen {
+		return true, false
+	}
+
+	c.closemu.Lock()
+	defer c.closemu.Unlock()
+
+	c.message = &Message{
+		app:      c.app,
+		delivery: &delivery,
+	}
+	c.fetched = false
+	c.unacked++
+	return false, true
+}
+
+func (c *Consumer) Err() error {
+	c.closemu.RLock()
+	defer c.closemu.RUnlock()
+	return c.lasterr
+}
+
+func (c *Consumer) Message() (worq.Message, error) {
+	c.closemu.Lock()
+	defer c.closemu.Unlock()
+
+	if c.closed {
+		// Nobody will settle the message returned by Next, so it is
+		// requeued to let the channel close
+		if c.message != nil && !c.fetched {
+			c.fetched = true
+			if err := c.message.delivery.Nack(false, true); err != nil {
+				c.broker.log().Warnf("amqpbroker: error requeuing message of closed consumer %s: %v", c.ctag, err)
+			}
+			c.unacked--
+			if err := c.closeChannelLocked(); err != nil {
+				c.broker.log().Warnf("amqpbroker: error closing channel of consumer %s: %v", c.ctag, err)
+			}
+		}
+		return nil, errors.New("amqp: consumer is closed")
+	}
+
+	if c.message == nil {
+		return nil, errors.New("amqp: Message called without calling Next")
+	}
+
+	c.fetched = true
+	return c.message, nil
+}
+
+func (c *Consumer) Close() error {
+	return c.close(nil)
+}
+
+func (c *Consumer) close(err error) error {
+	c.closemu.Lock()
+	defer c.closemu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	c.broker.removeConsumer(c)
+
+	if c.lasterr == nil {
+		c.lasterr = err
+	}
+
+	if c.ch == nil {
+		return nil
+	}
+
+	if err := c.ch.Cancel(
+		c.ctag, // consumer
+		true,   // noWait
+	); err != nil {
+		return err
+	}
+
+	return c.closeChannelLocked()
+}
+
+// closeChannelLocked closes the channel of a closed consumer once all of its
+// messages have been acknowledged.
+func (c *Consumer) closeChannelLocked() error {
+	if !c.closed || c.unacked > 0 || c.ch == nil {
+		return nil
+	}
+
+	ch := c.ch
+	c.ch = nil
+	return ch.Close()
+}
+
+// settle records that a message returned by Next has been acknowledged.
+func (c *Consumer) settle() {
+	c.closemu.Lock()
+	defer c.closemu.Unlock()
+
+	c.unacked--
+	if err := c.closeChannelLocked(); err != nil {
+		c.broker.log().Warnf("amqpbroker: error closing channel of consumer %s: %v", c.ctag, err)
+	}
+}
+
+func (c *Consumer) Ack(msg worq.Message) error {
+	defer c.settle()
+
+	delivery := msg.(*Message).delivery
+	if err := delivery.Ack(
+		false, // multiple
+	); err != nil {
+		return err
+	}
+
+	if c.queue != nil {
+		c.broker.saveOffset(c.queue, delivery)
+	}
+	return nil
+}
+
+// Nack rejects the message. A message that has reached the delivery limit of
+// its quorum queue is considered a poison message and dead-lettered rather
+// than requeued, which RabbitMQ would drop it on anyway.
+func (c *Consumer) Nack(msg worq.Message, requeue bool) error {
+	defer c.settle()
+
+	delivery := msg.(*Message).delivery
+	if requeue && c.queue != nil {
+		limit := c.queue.deliveryLimit()
+		if count := msg.(*Message).DeliveryCount(); limit > 0 && count > limit {
+			c.broker.log().Warnf("amqpbroker: dead-lettering poison message %s after %d deliveries", msg.ID(), count)
+			requeue = false
+		}
+	}
+
+	return delivery.Nack(
+		false,   // multiple
+		requeue, // requeue
+	)
+}
+
+// Delay publishes a copy of the message to a queue where it waits for the
+// delay before being dead-lettered back to the queue it was consumed from,
+// and then acknowledges it.
+func (c *Consumer) Delay(msg worq.Message, delay time.Duration) error {
+	if c.queue == nil {
+		return errors.New("amqpbroker: cannot delay broadcast messages")
+	}
+	if c.queue.isStream() {
+		// The copy would be appended to the stream as a duplicate
+		return errors.New("amqpbroker: cannot delay messages from a stream")
+	}
+
+	name, err := c.broker.ensureDelayQueue(c.queue.Name, delay)
+	if err != nil {
+		return err
+	}
+
+	s, err := c.broker.getSession()
+	if err != nil {
+		return err
+	}
+
+	confirm := make(chan error, 1)
+	if err := s.publish(
+		"",    // exchange
+		name,  // key
+		false, // mandatory
+		republishing(msg.(*Message).delivery),
+		func(err error) { confirm <- err },
+	); err != nil {
+		return err
+	}
+	if err := <-confirm; err != nil {
+		return err
+	}
+
+	return c.Ack(msg)
+}
+
+// republishing copies the delivery to a publishing. The delivery count is
+// dropped, as the copy is a new message to the broker.
+func republishing(delivery *amqp.Delivery) amqp.Publishing {
+	headers := make(amqp.Table, len(delivery.Headers))
+	for k, v := range delivery.Headers {
+		if k != "x-delivery-count" {
+			headers[k] = v
+		}
+	}
+
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		DeliveryMode:    delivery.DeliveryMode,
+		Priority:        delivery.Priority,
+		CorrelationId:   delivery.CorrelationId,
+		ReplyTo:         delivery.ReplyTo,
+		Expiration:      delivery.Expiration,
+		MessageId:       delivery.MessageId,
+		Timestamp:       delivery.Timestamp,
+		Type:            delivery.Type,
+		UserId:          delivery.UserId,
+		AppId:           delivery.AppId,
+		Body:            delivery.Body,
+	}
+}
@@ -0,0 +1,91 @@
+package amqpbroker
+
+import (
+	"time"
+
+	worq "github.com/jianyuan/go-worq"
+	"github.com/streadway/amqp"
+)
+
+var _ worq.Delivery = (*Message)(nil)
+
+type Message struct {
+	app      *worq.App
+	delivery *amqp.Delivery
+}
+
+func (msg *Message) Queue() string {
+	return msg.delivery.RoutingKey
+}
+
+func (msg *Message) ID() string {
+	id, err := msg.app.Protocol().ID(msg)
+	_ = err // TODO
+	return id
+}
+
+func (msg *Message) Task() string {
+	task, err := msg.app.Protocol().Task(msg)
+	_ = err // TODO
+	return task
+}
+
+func (msg *Message) Headers() map[string]interface{} {
+	m := make(map[string]interface{}, len(msg.delivery.Headers))
+	for k, v := range msg.delivery.Headers {
+		m[k] = v
+	}
+	return m
+}
+
+func (msg *Message) ContentType() string {
+	return msg.delivery.ContentType
+}
+
+func (msg *Message) Body() []byte {
+	return msg.delivery.Body
+}
+
+// Timestamp returns the time the message was published, in milliseconds if
+// it was published by worq.
+func (msg *Message) Timestamp() time.Time {
+	if ms := tableInt(msg.delivery.Headers, publishedAtHeader); ms > 0 {
+		return time.Unix(0, ms*int64(time.Millisecond))
+	}
+	return msg.delivery.Timestamp
+}
+
+func (msg *Message) Redelivered() bool {
+	return msg.delivery.Redelivered
+}
+
+func (msg *Message) DeliveryTag() uint64 {
+	return msg.delivery.DeliveryTag
+}
+
+func (msg *Message) Priority() uint8 {
+	return msg.delivery.Priority
+}
+
+func (msg *Message) CorrelationID() string {
+	return msg.delivery.CorrelationId
+}
+
+func (msg *Message) ReplyTo() string {
+	return msg.delivery.ReplyTo
+}
+
+func (msg *Message) AppID() string {
+	return msg.delivery.AppId
+}
+
+func (msg *Message) MessageID() string {
+	return msg.delivery.MessageId
+}
+
+// DeliveryCount returns the number of times the message has been delivered,
+// including this delivery. It is only tracked by quorum queues, and is 1 for
+// messages from other queues.
+func (msg *Message) DeliveryCount() int64 {
+	return tableInt(msg.delivery.Headers, "x-delivery-count") + 1
+}
@@ -0,0 +1,230 @@
+package amqpbroker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// errSessionClosed is returned for publishings whose channel was closed before
+// the broker confirmed them.
+var errSessionClosed = errors.New("amqpbroker: channel closed before publishing was confirmed")
+
+// UnroutableError is returned when a mandatory publishing could not be routed
+// to any queue, such as when the queue has not been declared.
+type UnroutableError struct {
+	Exchange  string
+	Key       string
+	ReplyCode uint16
+	ReplyText string
+}
+
+func (e *UnroutableError) Error() string {
+	return fmt.Sprintf("amqpbroker: publishing to exchange %q with key %q was returned: %d %s", e.Exchange, e.Key, e.ReplyCode, e.ReplyText)
+}
+
+// session is a channel used for publishing. When the channel is in confirm
+// mode, confirmations are correlated with publishings by delivery tag, so a
+// session can be shared by concurrent publishers.
+type session struct {
+	ch       *amqp.Channel
+	confirms bool
+
+	mu      sync.Mutex
+	closed  bool
+	nextTag uint64
+	pending map[uint64]*pendingPublishing
+}
+
+// pendingPublishing is a publishing waiting to be confirmed.
+type pendingPublishing struct {
+	exchange  string
+	key       string
+	mandatory bool
+	confirmed func(error)
+
+	// returned is set if the broker returned the publishing
+	returned *UnroutableError
+}
+
+func newSession(conn *amqp.Connection, logger logrus.FieldLogger) (*session, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &session{
+		ch:      ch,
+		pending: make(map[uint64]*pendingPublishing),
+	}
+
+	// Put this channel into confirm mode
+	if err := ch.Confirm(false); err != nil {
+		logger.Warnf("amqpbroker: publisher confirms not supported: %v", err)
+	} else {
+		s.confirms = true
+		go s.handleConfirms(
+			ch.NotifyPublish(make(chan amqp.Confirmation, 64)),
+			ch.NotifyReturn(make(chan amqp.Return, 64)),
+		)
+	}
+
+	go s.watch(logger, ch.NotifyClose(make(chan *amqp.Error, 1)))
+
+	return s, nil
+}
+
+// publish sends msg and calls confirmed with nil once the broker has
+// confirmed it, or an error if it was not acknowledged or, when mandatory, it
+// was returned as unroutable. confirmed is not called if publish returns an
+// error.
+func (s *session) publish(exchange, key string, mandatory bool, msg amqp.Publishing, confirmed func(error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errSessionClosed
+	}
+
+	// Publishing and assigning the delivery tag must happen atomically so
+	// that tags match the order the broker sees publishings in
+	if err := s.ch.Publish(
+		exchange,  // exchange
+		key,       // key
+		mandatory, // mandatory
+		false,     // immediate
+		msg,
+	); err != nil {
+		return err
+	}
+
+	if !s.confirms {
+		confirmed(nil)
+		return nil
+	}
+
+	s.nextTag++
+	s.pending[s.nextTag] = &pendingPublishing{
+		exchange:  exchange,
+		key:       key,
+		mandatory: mandatory,
+		confirmed: confirmed,
+	}
+	return nil
+}
+
+func (s *session) handleConfirms(confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	defer s.shutdown()
+
+	for {
+		select {
+		case returned, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			s.markReturned(returned)
+
+		case confirmed, ok := <-confirms:
+			if !ok {
+				return
+			}
+
+			// The broker sends a return before the confirmation of the
+			// same publishing, and the client queues it before reading
+			// further, so any return for this publishing is already
+			// waiting
+			for drained := false; !drained && returns != nil; {
+				select {
+				case returned, ok := <-returns:
+					if !ok {
+						returns = nil
+						continue
+					}
+					s.markReturned(returned)
+				default:
+					drained = true
+				}
+			}
+
+			s.confirm(confirmed)
+		}
+	}
+}
+
+// markReturned marks the oldest pending mandatory publishing with the route
+// of the return as returned. Publishings with the same route are routed, and
+// so returned, in the order they were published.
+func (s *session) markReturned(returned amqp.Return) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var oldest uint64
+	for tag, p := range s.pending {
+		if p.mandatory && p.returned == nil && p.exchange == returned.Exchange && p.key == returned.RoutingKey {
+			if oldest == 0 || tag < oldest {
+				oldest = tag
+			}
+		}
+	}
+
+	if oldest == 0 {
+		return
+	}
+
+	s.pending[oldest].returned = &UnroutableError{
+		Exchange:  returned.Exchange,
+		Key:       returned.RoutingKey,
+		ReplyCode: returned.ReplyCode,
+		ReplyText: returned.ReplyText,
+	}
+}
+
+func (s *session) confirm(confirmed amqp.Confirmation) {
+	s.mu.Lock()
+	p, ok := s.pending[confirmed.DeliveryTag]
+	delete(s.pending, confirmed.DeliveryTag)
+	s.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	switch {
+	case !confirmed.Ack:
+		p.confirmed(ErrNotAcknowledged)
+	case p.returned != nil:
+		p.confirmed(p.returned)
+	default:
+		p.confirmed(nil)
+	}
+}
+
+func (s *session) watch(logger logrus.FieldLogger, notify chan *amqp.Error) {
+	if amqpErr, ok := <-notify; ok && amqpErr != nil {
+		logger.Warnf("amqpbroker: channel closed: %v", amqpErr)
+	}
+	s.shutdown()
+}
+
+// shutdown marks the session as closed and fails the publishings waiting for
+// confirmation.
+func (s *session) shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for tag, p := range s.pending {
+		p.confirmed(errSessionClosed)
+		delete(s.pending, tag)
+	}
+}
+
+func (s *session) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
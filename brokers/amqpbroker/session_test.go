@@ -0,0 +1,34 @@
+package amqpbroker
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession_returnedPublishing(t *testing.T) {
+	results := make(map[uint64]error)
+	s := &session{pending: make(map[uint64]*pendingPublishing)}
+	for tag, key := range map[uint64]string{1: "missing", 2: "tasks", 3: "missing"} {
+		tag := tag
+		s.pending[tag] = &pendingPublishing{
+			exchange:  "go-worq",
+			key:       key,
+			mandatory: true,
+			confirmed: func(err error) { results[tag] = err },
+		}
+	}
+
+	s.markReturned(amqp.Return{Exchange: "go-worq", RoutingKey: "missing", ReplyCode: 312, ReplyText: "NO_ROUTE"})
+	for tag := uint64(1); tag <= 3; tag++ {
+		s.confirm(amqp.Confirmation{DeliveryTag: tag, Ack: true})
+	}
+
+	if assert.IsType(t, &UnroutableError{}, results[1]) {
+		assert.Equal(t, uint16(312), results[1].(*UnroutableError).ReplyCode)
+	}
+	assert.NoError(t, results[2])
+	assert.NoError(t, results[3])
+	assert.Empty(t, s.pending)
+}
@@ -0,0 +1,123 @@
+package amqpbroker
+
+import (
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// OffsetStore keeps track of the offset of the last message acknowledged from
+// each stream, so that consumers resume from where they left off.
+type OffsetStore interface {
+	// Load returns the offset stored for the queue, and whether there is one.
+	Load(queue string) (offset int64, ok bool, err error)
+
+	// Save stores the offset for the queue.
+	Save(queue string, offset int64) error
+}
+
+var _ OffsetStore = (*memoryOffsetStore)(nil)
+
+type memoryOffsetStore struct {
+	mu      sync.Mutex
+	offsets map[string]int64
+}
+
+// NewMemoryOffsetStore returns an OffsetStore that keeps offsets in memory, so
+// they are lost when the process exits.
+func NewMemoryOffsetStore() OffsetStore {
+	return &memoryOffsetStore{
+		offsets: make(map[string]int64),
+	}
+}
+
+func (s *memoryOffsetStore) Load(queue string) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offset, ok := s.offsets[queue]
+	return offset, ok, nil
+}
+
+func (s *memoryOffsetStore) Save(queue string, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Messages may be acknowledged out of order
+	if current, ok := s.offsets[queue]; !ok || offset > current {
+		s.offsets[queue] = offset
+	}
+	return nil
+}
+
+// SetOffsetStore sets the store that the offsets of stream queues are
+// tracked in. Consumers of a stream start after the stored offset, or at the
+// x-stream-offset given in the ConsumeArgs of the queue if there is none.
+func SetOffsetStore(store OffsetStore) OptionFunc {
+	return func(b *Broker) error {
+		b.offsetStore = store
+		return nil
+	}
+}
+
+// isStream reports whether the queue is a RabbitMQ stream.
+func (q *Queue) isStream() bool {
+	return q.Args["x-queue-type"] == "stream"
+}
+
+// deliveryLimit returns the delivery limit of a quorum queue, or 0 if it has
+// none.
+func (q *Queue) deliveryLimit() int64 {
+	if q.Args["x-queue-type"] != "quorum" {
+		return 0
+	}
+	return tableInt(q.Args, "x-delivery-limit")
+}
+
+// consumeArgs returns the arguments to consume from the queue with.
+func (b *Broker) consumeArgs(q *Queue) (amqp.Table, error) {
+	args := make(amqp.Table, len(q.ConsumeArgs)+1)
+	for k, v := range q.ConsumeArgs {
+		args[k] = v
+	}
+
+	if q.isStream() && b.offsetStore != nil {
+		offset, ok, err := b.offsetStore.Load(q.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			args["x-stream-offset"] = offset + 1
+		}
+	}
+
+	return args, nil
+}
+
+// saveOffset records the offset of a message acknowledged from a stream.
+func (b *Broker) saveOffset(q *Queue, delivery *amqp.Delivery) {
+	if !q.isStream() || b.offsetStore == nil {
+		return
+	}
+
+	if _, ok := delivery.Headers["x-stream-offset"]; !ok {
+		return
+	}
+
+	if err := b.offsetStore.Save(q.Name, tableInt(delivery.Headers, "x-stream-offset")); err != nil {
+		b.log().Errorf("amqpbroker: error saving offset of stream %s: %v", q.Name, err)
+	}
+}
+
+func tableInt(table amqp.Table, key string) int64 {
+	switch value := table[key].(type) {
+	case int:
+		return int64(value)
+	case int16:
+		return int64(value)
+	case int32:
+		return int64(value)
+	case int64:
+		return value
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,64 @@
+package amqpbroker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+
+	worq "github.com/jianyuan/go-worq"
+)
+
+func TestMemoryOffsetStore_keepsLatest(t *testing.T) {
+	store := NewMemoryOffsetStore()
+
+	_, ok, err := store.Load("tasks")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, store.Save("tasks", 5))
+	assert.NoError(t, store.Save("tasks", 3))
+
+	offset, ok, err := store.Load("tasks")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(5), offset)
+}
+
+func TestBroker_consumeArgs_resumesStream(t *testing.T) {
+	b, err := New(nil, SetOffsetStore(NewMemoryOffsetStore()))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	q := StreamQueue("log", "first")
+	args, err := b.consumeArgs(&q)
+	assert.NoError(t, err)
+	assert.Equal(t, amqp.Table{"x-stream-offset": "first"}, args)
+
+	b.saveOffset(&q, &amqp.Delivery{Headers: amqp.Table{"x-stream-offset": int64(41)}})
+	args, err = b.consumeArgs(&q)
+	assert.NoError(t, err)
+	assert.Equal(t, amqp.Table{"x-stream-offset": int64(42)}, args)
+}
+
+func TestBroker_prefetchCount_limitsStreams(t *testing.T) {
+	app, err := worq.New(worq.SetConcurrency(4))
+	if !assert.NoError(t, err) {
+		return
+	}
+	b, err := New(nil, SetPrefetch(0))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, 0, b.prefetchCount(app, "tasks", false))
+	assert.Equal(t, 4, b.prefetchCount(app, "log", true))
+}
+
+func TestConsumer_Delay_rejectsStreams(t *testing.T) {
+	q := StreamQueue("log", nil)
+	c := &Consumer{queue: &q}
+	assert.Error(t, c.Delay(&Message{delivery: new(amqp.Delivery)}, time.Second))
+}
@@ -0,0 +1,313 @@
+package amqpbroker
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Queue describes an AMQP queue, such as a quorum queue or one with a
+// maximum priority, message TTL or dead letter exchange set in Args.
+type Queue struct {
+	Name       string
+	Durable    bool
+	AutoDelete bool
+	Exclusive  bool
+	Args       amqp.Table
+
+	// Bindings routes messages from exchanges to the queue. A queue without
+	// bindings is bound to the exchange of the broker using its name as the
+	// routing key.
+	Bindings []Binding
+
+	// ConsumeArgs are the arguments that consumers of the queue use, such
+	// as x-stream-offset.
+	ConsumeArgs amqp.Table
+}
+
+// Binding describes the binding of a queue to an exchange.
+type Binding struct {
+	Exchange string
+	Key      string
+	Args     amqp.Table
+}
+
+// DeclareExchange declares the exchange whenever the broker connects.
+func DeclareExchange(ex Exchange) OptionFunc {
+	return func(b *Broker) error {
+		if ex.Name == "" {
+			return errors.New("amqpbroker.DeclareExchange: exchange must have a name")
+		}
+		b.exchanges = append(b.exchanges, &ex)
+		return nil
+	}
+}
+
+// DeclareQueue declares the queue and its bindings whenever the broker
+// connects, so that messages published before any worker consumes from the
+// queue are not dropped. Consume uses the declaration of the queue.
+func DeclareQueue(queue Queue) OptionFunc {
+	return func(b *Broker) error {
+		if queue.Name == "" {
+			return errors.New("amqpbroker.DeclareQueue: queue must have a name")
+		}
+		if _, ok := b.queues[queue.Name]; !ok {
+			b.queueOrder = append(b.queueOrder, queue.Name)
+		}
+		b.queues[queue.Name] = &queue
+		return nil
+	}
+}
+
+// declareTopology declares the exchange of the broker and the exchanges and
+// queues given to DeclareExchange and DeclareQueue.
+func (b *Broker) declareTopology(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err := b.declareExchange(ch); err != nil {
+		return err
+	}
+
+	for _, ex := range b.exchanges {
+		if err := ch.ExchangeDeclare(
+			ex.Name,       // name
+			ex.Kind,       // kind
+			ex.Durable,    // durable
+			ex.AutoDelete, // autoDelete
+			false,         // internal
+			false,         // noWait
+			ex.Args,       // args
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range b.queueOrder {
+		if _, err := b.declareQueueTopology(ch, b.queues[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureQueue declares the queue, unless it has already been declared on the
+// current connection.
+func (b *Broker) ensureQueue(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	conn, err := b.getConnLocked()
+	if err != nil {
+		return err
+	}
+
+	if b.declared[name] {
+		return nil
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if _, err := b.declareQueueTopology(ch, b.queue(name)); err != nil {
+		return err
+	}
+
+	if b.declared == nil {
+		b.declared = make(map[string]bool)
+	}
+	b.declared[name] = true
+	return nil
+}
+
+// ensureDelayQueue declares the queue that holds messages for the delay
+// before dead-lettering them back to the queue, unless it has already been
+// declared on the current connection, and returns its name.
+func (b *Broker) ensureDelayQueue(queue string, delay time.Duration) (string, error) {
+	ms := int64(delay / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	name := fmt.Sprintf("%s.delay.%d", queue, ms)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	conn, err := b.getConnLocked()
+	if err != nil {
+		return "", err
+	}
+
+	if b.declared[name] {
+		return name, nil
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return "", err
+	}
+	defer ch.Close()
+
+	if _, err := ch.QueueDeclare(
+		name,  // name
+		true,  // durable
+		false, // autoDelete
+		false, // exclusive
+		false, // noWait
+		amqp.Table{
+			"x-message-ttl":             ms,
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		}, // args
+	); err != nil {
+		return "", err
+	}
+
+	if b.declared == nil {
+		b.declared = make(map[string]bool)
+	}
+	b.declared[name] = true
+	return name, nil
+}
+
+// ensureBroadcastExchange declares the broadcast exchange, unless it has
+// already been declared on the current connection. It uses a channel of its
+// own, so a failed declaration does not close a channel used for publishing.
+func (b *Broker) ensureBroadcastExchange(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	conn, err := b.getConnLocked()
+	if err != nil {
+		return err
+	}
+
+	if b.declaredExchanges[name] {
+		return nil
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err := b.declareBroadcastExchange(ch, name); err != nil {
+		return err
+	}
+
+	if b.declaredExchanges == nil {
+		b.declaredExchanges = make(map[string]bool)
+	}
+	b.declaredExchanges[name] = true
+	return nil
+}
+
+func (b *Broker) declareExchange(ch *amqp.Channel) error {
+	return ch.ExchangeDeclare(
+		b.exchange,     // name
+		b.exchangeType, // kind
+		true,           // durable
+		false,          // autoDelete
+		false,          // internal
+		false,          // noWait
+		nil,            // args
+	)
+}
+
+func (b *Broker) declareQueueTopology(ch *amqp.Channel, q *Queue) (string, error) {
+	queue, err := ch.QueueDeclare(
+		q.Name,       // name
+		q.Durable,    // durable
+		q.AutoDelete, // autoDelete
+		q.Exclusive,  // exclusive
+		false,        // noWait
+		q.Args,       // args
+	)
+	if err != nil {
+		return "", err
+	}
+
+	bindings := q.Bindings
+	if len(bindings) == 0 {
+		bindings = []Binding{{Exchange: b.exchange, Key: queue.Name}}
+	}
+
+	for _, binding := range bindings {
+		if err := ch.QueueBind(
+			queue.Name,       // name
+			binding.Key,      // key
+			binding.Exchange, // exchange
+			false,            // noWait
+			binding.Args,     // args
+		); err != nil {
+			return "", err
+		}
+	}
+
+	return queue.Name, nil
+}
+
+// QuorumQueue returns a durable quorum queue. Messages are dead-lettered, or
+// dropped, once they have been delivered more than deliveryLimit times; a
+// deliveryLimit of 0 means no limit.
+func QuorumQueue(name string, deliveryLimit int) Queue {
+	args := amqp.Table{"x-queue-type": "quorum"}
+	if deliveryLimit > 0 {
+		args["x-delivery-limit"] = int64(deliveryLimit)
+	}
+	return Queue{Name: name, Durable: true, Args: args}
+}
+
+// StreamQueue returns a RabbitMQ stream. Messages stay in the stream after
+// they are acknowledged, so tasks can be replayed by consuming from an
+// earlier offset, such as "first", which is set in ConsumeArgs.
+func StreamQueue(name string, offset interface{}) Queue {
+	q := Queue{
+		Name:    name,
+		Durable: true,
+		Args:    amqp.Table{"x-queue-type": "stream"},
+	}
+	if offset != nil {
+		q.ConsumeArgs = amqp.Table{"x-stream-offset": offset}
+	}
+	return q
+}
+
+// UseQuorumQueues declares queues that have not been given to DeclareQueue
+// as quorum queues with the delivery limit.
+func UseQuorumQueues(deliveryLimit int) OptionFunc {
+	return func(b *Broker) error {
+		for k, v := range QuorumQueue("", deliveryLimit).Args {
+			b.defaultQueueArgs[k] = v
+		}
+		return nil
+	}
+}
+
+// SetMaxPriority declares queues that have not been given to DeclareQueue
+// with the maximum priority, so that messages with a higher priority are
+// delivered first. Quorum queues do not support priorities.
+func SetMaxPriority(max uint8) OptionFunc {
+	return func(b *Broker) error {
+		b.defaultQueueArgs["x-max-priority"] = int64(max)
+		return nil
+	}
+}
+
+// queue returns the declaration of the queue.
+func (b *Broker) queue(name string) *Queue {
+	if q, ok := b.queues[name]; ok {
+		return q
+	}
+	return &Queue{Name: name, Durable: true, Args: b.defaultQueueArgs}
+}
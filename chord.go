@@ -0,0 +1,73 @@
+package worq
+
+import (
+	"fmt"
+	"sync"
+)
+
+type GroupResult struct {
+	ID      string
+	Results []*AsyncResult
+}
+
+// ChordStore collects the results of the tasks in a chord header.
+type ChordStore interface {
+	// Add records the result of the task at index in the group. Once every
+	// task in the group has reported, it returns their results in order and
+	// done is true.
+	Add(group string, index, size int, result interface{}) (results []interface{}, done bool, err error)
+}
+
+type memoryChordStore struct {
+	mu     sync.Mutex
+	groups map[string]*chordState
+}
+
+type chordState struct {
+	results []interface{}
+	seen    []bool
+	count   int
+}
+
+// NewMemoryChordStore returns a ChordStore that keeps results in memory. It
+// only works when every task of the chord runs in the same process.
+func NewMemoryChordStore() ChordStore {
+	return &memoryChordStore{
+		groups: make(map[string]*chordState),
+	}
+}
+
+func (s *memoryChordStore) Add(group string, index, size int, result interface{}) ([]interface{}, bool, error) {
+	if index < 0 || index >= size {
+		return nil, false, fmt.Errorf("worq: chord index %d out of range for group %s of size %d", index, group, size)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.groups[group]
+	if !ok {
+		state = &chordState{
+			results: make([]interface{}, size),
+			seen:    make([]bool, size),
+		}
+		s.groups[group] = state
+	}
+
+	if len(state.results) != size {
+		return nil, false, fmt.Errorf("worq: chord size mismatch for group %s: %d != %d", group, size, len(state.results))
+	}
+
+	state.results[index] = result
+	if !state.seen[index] {
+		state.seen[index] = true
+		state.count++
+	}
+
+	if state.count < size {
+		return nil, false, nil
+	}
+
+	delete(s.groups, group)
+	return state.results, true, nil
+}
@@ -0,0 +1,38 @@
+package worq
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryChordStore_Add(t *testing.T) {
+	store := NewMemoryChordStore()
+
+	results, done, err := store.Add("g", 1, 2, "b")
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.Nil(t, results)
+
+	// Redelivered parts are not counted twice
+	_, done, err = store.Add("g", 1, 2, "b")
+	assert.NoError(t, err)
+	assert.False(t, done)
+
+	results, done, err = store.Add("g", 0, 2, "a")
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, []interface{}{"a", "b"}, results)
+}
+
+func TestMemoryChordStore_Add_errors(t *testing.T) {
+	store := NewMemoryChordStore()
+
+	_, _, err := store.Add("g", 2, 2, nil)
+	assert.EqualError(t, err, "worq: chord index 2 out of range for group g of size 2")
+
+	_, _, err = store.Add("g", 0, 2, nil)
+	assert.NoError(t, err)
+	_, _, err = store.Add("g", 0, 3, nil)
+	assert.EqualError(t, err, "worq: chord size mismatch for group g: 3 != 2")
+}
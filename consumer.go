@@ -1,5 +1,7 @@
 package worq
 
+import "time"
+
 type Consumer interface {
 	Next() bool
 	Err() error
@@ -9,3 +11,11 @@ type Consumer interface {
 	Ack(message Message) error
 	Nack(message Message, requeue bool) error
 }
+
+// DelayingConsumer is implemented by consumers that can put a message back on
+// its queue after a delay without holding on to it in the meantime.
+type DelayingConsumer interface {
+	// Delay acknowledges the message and publishes it to its queue again
+	// once the delay has elapsed.
+	Delay(message Message, delay time.Duration) error
+}
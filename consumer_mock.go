@@ -0,0 +1,100 @@
+package worq
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	_ Consumer         = (*MockConsumer)(nil)
+	_ DelayingConsumer = (*MockConsumer)(nil)
+)
+
+// MockConsumer is a Consumer that records the messages acknowledged and
+// rejected through it.
+type MockConsumer struct {
+	mu       sync.Mutex
+	acked    []Message
+	nacked   []Message
+	requeued []Message
+	delayed  []Message
+
+	// DelayError, if set, is returned by Delay instead of delaying the
+	// message.
+	DelayError error
+}
+
+func (c *MockConsumer) Next() bool {
+	return false
+}
+
+func (c *MockConsumer) Err() error {
+	return nil
+}
+
+func (c *MockConsumer) Message() (Message, error) {
+	return nil, errors.New("worq: MockConsumer has no messages")
+}
+
+func (c *MockConsumer) Close() error {
+	return nil
+}
+
+func (c *MockConsumer) Ack(msg Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.acked = append(c.acked, msg)
+	return nil
+}
+
+func (c *MockConsumer) Nack(msg Message, requeue bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if requeue {
+		c.requeued = append(c.requeued, msg)
+	} else {
+		c.nacked = append(c.nacked, msg)
+	}
+	return nil
+}
+
+// Delay records the message as delayed and acknowledges it.
+func (c *MockConsumer) Delay(msg Message, delay time.Duration) error {
+	if c.DelayError != nil {
+		return c.DelayError
+	}
+
+	c.mu.Lock()
+	c.delayed = append(c.delayed, msg)
+	c.mu.Unlock()
+	return c.Ack(msg)
+}
+
+// Acked returns the messages acknowledged so far.
+func (c *MockConsumer) Acked() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Message(nil), c.acked...)
+}
+
+// Nacked returns the messages rejected without being requeued so far.
+func (c *MockConsumer) Nacked() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Message(nil), c.nacked...)
+}
+
+// Requeued returns the messages rejected and requeued so far.
+func (c *MockConsumer) Requeued() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Message(nil), c.requeued...)
+}
+
+// Delayed returns the messages delayed so far.
+func (c *MockConsumer) Delayed() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Message(nil), c.delayed...)
+}
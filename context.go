@@ -1,9 +1,11 @@
 package worq
 
 import (
+	stdcontext "context"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Context interface {
@@ -11,6 +13,10 @@ type Context interface {
 
 	Logger() logrus.FieldLogger
 
+	// Span returns the span tracing the task. Spans started from the
+	// Context are children of it.
+	Span() trace.Span
+
 	Consumer() Consumer
 
 	Message() Message
@@ -19,6 +25,10 @@ type Context interface {
 
 	Reject(requeue bool) error
 
+	// SetResult records the result of the task. It is passed on to the next
+	// task of a chain or to the callback of a chord.
+	SetResult(v interface{})
+
 	Deadline() (deadline time.Time, ok bool)
 
 	Done() <-chan struct{}
@@ -49,12 +59,13 @@ func (*emptyCtx) Value(key interface{}) interface{} {
 }
 
 type context struct {
-	emptyCtx
+	stdcontext.Context
 
 	app      *App
 	logger   logrus.FieldLogger
 	consumer Consumer
 	msg      Message
+	result   interface{}
 }
 
 func (ctx *context) App() *App {
@@ -68,6 +79,10 @@ func (ctx *context) Logger() logrus.FieldLogger {
 	return ctx.app.logger
 }
 
+func (ctx *context) Span() trace.Span {
+	return trace.SpanFromContext(ctx)
+}
+
 func (ctx *context) Consumer() Consumer {
 	return ctx.consumer
 }
@@ -83,3 +98,7 @@ func (ctx *context) Bind(v interface{}) error {
 func (ctx *context) Reject(requeue bool) error {
 	return &TaskRejected{Requeue: requeue}
 }
+
+func (ctx *context) SetResult(v interface{}) {
+	ctx.result = v
+}
@@ -2,12 +2,14 @@ package worq
 
 import (
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type MockContext struct {
 	emptyCtx
 
 	MessageFactory func() Message
+	Result         interface{}
 }
 
 func NewMockContext() *MockContext {
@@ -19,7 +21,11 @@ func (ctx *MockContext) App() *App {
 }
 
 func (ctx *MockContext) Logger() logrus.FieldLogger {
-	return nil
+	return logrus.StandardLogger()
+}
+
+func (ctx *MockContext) Span() trace.Span {
+	return trace.SpanFromContext(ctx)
 }
 
 func (ctx *MockContext) Consumer() Consumer {
@@ -37,3 +43,7 @@ func (ctx *MockContext) Bind(v interface{}) error {
 func (ctx *MockContext) Reject(requeue bool) error {
 	return nil
 }
+
+func (ctx *MockContext) SetResult(v interface{}) {
+	ctx.Result = v
+}
@@ -0,0 +1,462 @@
+package worq
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrBroadcastUnsupported is returned when the broker cannot broadcast
+// control commands to workers.
+var ErrBroadcastUnsupported = errors.New("worq: broker does not support broadcasting")
+
+// ErrNotStarted is returned by control commands that need a running worker.
+var ErrNotStarted = errors.New("worq: worker is not started")
+
+// Control commands understood by workers.
+const (
+	ControlPing           = "ping"
+	ControlActive         = "active"
+	ControlRegistered     = "registered"
+	ControlStats          = "stats"
+	ControlPoolGrow       = "pool_grow"
+	ControlPoolShrink     = "pool_shrink"
+	ControlAddConsumer    = "add_consumer"
+	ControlCancelConsumer = "cancel_consumer"
+	ControlShutdown       = "shutdown"
+	ControlRevoke         = "revoke"
+	ControlRateLimit      = "rate_limit"
+)
+
+// ControlMessage is a command broadcast to workers. It follows the format of
+// Celery's pidbox so commands can be exchanged with Celery workers.
+type ControlMessage struct {
+	Method      string          `json:"method"`
+	Arguments   json.RawMessage `json:"arguments"`
+	Destination []string        `json:"destination"`
+	ReplyTo     *ControlReplyTo `json:"reply_to,omitempty"`
+	Ticket      string          `json:"ticket,omitempty"`
+}
+
+// ControlReplyTo is where workers send their replies to a command.
+type ControlReplyTo struct {
+	Exchange   string `json:"exchange"`
+	RoutingKey string `json:"routing_key"`
+}
+
+// ControlRequest is a command sent to workers with App.Control.
+type ControlRequest struct {
+	Method    string
+	Arguments interface{}
+
+	// Destination limits the command to the workers with these hostnames.
+	// The command is sent to every worker if it is empty.
+	Destination []string
+
+	// Timeout is how long to wait for replies. It defaults to one second.
+	Timeout time.Duration
+
+	// Limit stops waiting for replies once this many have been received.
+	Limit int
+}
+
+// ControlReply is the reply of a worker to a command.
+type ControlReply struct {
+	Hostname string
+	Reply    json.RawMessage
+}
+
+type revokeArguments struct {
+	TaskID    taskIDs `json:"task_id"`
+	Terminate bool    `json:"terminate"`
+}
+
+type rateLimitArguments struct {
+	TaskName  string `json:"task_name"`
+	RateLimit string `json:"rate_limit"`
+}
+
+type poolArguments struct {
+	N int `json:"n"`
+}
+
+type consumerArguments struct {
+	Queue string `json:"queue"`
+}
+
+// taskIDs decodes either a single task ID or a list of them.
+type taskIDs []string
+
+func (ids *taskIDs) UnmarshalJSON(data []byte) error {
+	var id string
+	if err := json.Unmarshal(data, &id); err == nil {
+		*ids = taskIDs{id}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(ids))
+}
+
+// Revoke tells every worker to discard the task with the given ID. If
+// terminate is true, the context of the task is cancelled if it is already
+// running.
+func (app *App) Revoke(id string, terminate bool) error {
+	if err := app.revoke(id, terminate); err != nil {
+		return err
+	}
+	return app.broadcastControl(&ControlMessage{Method: ControlRevoke}, &revokeArguments{
+		TaskID:    taskIDs{id},
+		Terminate: terminate,
+	})
+}
+
+// Control sends a command to the workers and gathers their replies until the
+// timeout elapses or the limit of replies is reached.
+func (app *App) Control(req *ControlRequest) ([]*ControlReply, error) {
+	b, ok := app.broker.(Broadcaster)
+	if !ok {
+		return nil, ErrBroadcastUnsupported
+	}
+
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	ticket := app.idFunc()
+	replyTo := &ControlReplyTo{
+		Exchange:   "reply." + app.controlExchange,
+		RoutingKey: ticket,
+	}
+
+	consumer, err := b.Subscribe(app.Context(), replyTo.Exchange, replyTo.RoutingKey)
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+
+	if err := app.broadcastControl(&ControlMessage{
+		Method:      req.Method,
+		Destination: req.Destination,
+		ReplyTo:     replyTo,
+		Ticket:      ticket,
+	}, req.Arguments); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	messages := make(chan Message)
+	go func() {
+		defer close(messages)
+		for consumer.Next() {
+			msg, err := consumer.Message()
+			if err != nil {
+				return
+			}
+			consumer.Ack(msg)
+
+			select {
+			case messages <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var replies []*ControlReply
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return replies, consumer.Err()
+			}
+
+			if t, _ := msg.Headers()["ticket"].(string); t != ticket {
+				continue
+			}
+
+			var body map[string]json.RawMessage
+			if err := json.Unmarshal(msg.Body(), &body); err != nil {
+				app.logger.Errorf("error decoding control reply: %v", err)
+				continue
+			}
+			for hostname, reply := range body {
+				replies = append(replies, &ControlReply{
+					Hostname: hostname,
+					Reply:    reply,
+				})
+			}
+
+			if req.Limit > 0 && len(replies) >= req.Limit {
+				return replies, nil
+			}
+
+		case <-timer.C:
+			return replies, nil
+		}
+	}
+}
+
+func (app *App) broadcastControl(cm *ControlMessage, args interface{}) error {
+	b, ok := app.broker.(Broadcaster)
+	if !ok {
+		return ErrBroadcastUnsupported
+	}
+
+	if args == nil {
+		args = struct{}{}
+	}
+
+	var err error
+	cm.Arguments, err = json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(cm)
+	if err != nil {
+		return err
+	}
+
+	return b.Broadcast(app.controlExchange, &Publishing{
+		ID:          app.idFunc(),
+		ContentType: "application/json",
+		Body:        body,
+	})
+}
+
+// consumeControl handles the control commands broadcast to the workers.
+func (app *App) consumeControl(ctx stdcontext.Context, b Broadcaster) error {
+	consumer, err := b.Subscribe(app.Context(), app.controlExchange, "")
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			consumer.Close()
+		case <-done:
+		}
+	}()
+
+	for consumer.Next() {
+		msg, err := consumer.Message()
+		if err != nil {
+			return err
+		}
+
+		if err := app.onControlMessage(b, msg); err != nil {
+			app.logger.Errorf("error handling control command: %v", err)
+		}
+
+		if err := consumer.Ack(msg); err != nil {
+			return err
+		}
+	}
+
+	return consumer.Err()
+}
+
+func (app *App) onControlMessage(b Broadcaster, msg Message) error {
+	var cm ControlMessage
+	if err := json.Unmarshal(msg.Body(), &cm); err != nil {
+		return err
+	}
+
+	if len(cm.Destination) > 0 && !containsString(cm.Destination, app.hostname) {
+		return nil
+	}
+
+	reply, err := app.handleControl(&cm)
+	if err != nil {
+		app.logger.WithField("method", cm.Method).Errorf("error handling control command: %v", err)
+		reply = map[string]string{"error": err.Error()}
+	}
+
+	if cm.ReplyTo == nil {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{app.hostname: reply})
+	if err != nil {
+		return err
+	}
+
+	return b.Broadcast(cm.ReplyTo.Exchange, &Publishing{
+		ID:    app.idFunc(),
+		Queue: cm.ReplyTo.RoutingKey,
+		Headers: map[string]interface{}{
+			"ticket": cm.Ticket,
+		},
+		ContentType: "application/json",
+		Body:        body,
+	})
+}
+
+func (app *App) handleControl(cm *ControlMessage) (interface{}, error) {
+	switch cm.Method {
+	case ControlPing:
+		return okReply("pong"), nil
+
+	case ControlRevoke:
+		var args revokeArguments
+		if err := json.Unmarshal(cm.Arguments, &args); err != nil {
+			return nil, err
+		}
+		for _, id := range args.TaskID {
+			if err := app.revoke(id, args.Terminate); err != nil {
+				return nil, err
+			}
+		}
+		return okReply("tasks " + strings.Join(args.TaskID, ", ") + " flagged as revoked"), nil
+
+	case ControlRateLimit:
+		var args rateLimitArguments
+		if err := json.Unmarshal(cm.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if err := app.SetRateLimit(args.TaskName, args.RateLimit); err != nil {
+			return nil, err
+		}
+		if args.RateLimit == "" {
+			return okReply("rate limit disabled successfully"), nil
+		}
+		return okReply("new rate limit set successfully"), nil
+
+	case ControlRegistered:
+		var names []string
+		app.taskMap.Range(func(key, value interface{}) bool {
+			names = append(names, key.(string))
+			return true
+		})
+		sort.Strings(names)
+		return names, nil
+	}
+
+	w := app.currentWorker()
+	if w == nil {
+		return nil, ErrNotStarted
+	}
+
+	switch cm.Method {
+	case ControlActive:
+		tasks := []map[string]interface{}{}
+		app.active.Range(func(key, value interface{}) bool {
+			task := value.(*activeTask)
+			tasks = append(tasks, map[string]interface{}{
+				"id":         task.id,
+				"name":       task.name,
+				"hostname":   app.hostname,
+				"time_start": float64(task.started.UnixNano()) / float64(time.Second),
+				"delivery_info": map[string]interface{}{
+					"routing_key": task.queue,
+				},
+			})
+			return true
+		})
+		return tasks, nil
+
+	case ControlStats:
+		w.statsMu.Lock()
+		total := make(map[string]int, len(w.total))
+		for name, n := range w.total {
+			total[name] = n
+		}
+		w.statsMu.Unlock()
+
+		return map[string]interface{}{
+			"pid":    os.Getpid(),
+			"uptime": int(time.Since(w.started).Seconds()),
+			"total":  total,
+			"pool": map[string]interface{}{
+				"max-concurrency": w.concurrency(),
+			},
+			"queues": w.queues(),
+		}, nil
+
+	case ControlPoolGrow, ControlPoolShrink:
+		args := poolArguments{N: 1}
+		if err := json.Unmarshal(cm.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if cm.Method == ControlPoolGrow {
+			w.resize(w.concurrency() + args.N)
+			return okReply("pool will grow"), nil
+		}
+		w.resize(w.concurrency() - args.N)
+		return okReply("pool will shrink"), nil
+
+	case ControlAddConsumer:
+		var args consumerArguments
+		if err := json.Unmarshal(cm.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if err := w.addConsumer(args.Queue); err != nil {
+			return nil, err
+		}
+		return okReply("add consumer " + args.Queue), nil
+
+	case ControlCancelConsumer:
+		var args consumerArguments
+		if err := json.Unmarshal(cm.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if err := w.cancelConsumer(args.Queue); err != nil {
+			return nil, err
+		}
+		return okReply("no longer consuming from " + args.Queue), nil
+
+	case ControlShutdown:
+		app.logger.Warn("Shutdown requested")
+		w.stop()
+		return okReply("shutting down"), nil
+	}
+
+	return nil, fmt.Errorf("worq: unknown control command %s", cm.Method)
+}
+
+func okReply(msg string) map[string]string {
+	return map[string]string{"ok": msg}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// revoke records the task as revoked on this worker, and cancels its context
+// if it is running or waiting for its rate limit and terminate is true.
+func (app *App) revoke(id string, terminate bool) error {
+	if err := app.revokeStore.Add(id); err != nil {
+		return err
+	}
+
+	app.logger.WithField("id", id).Info("Task revoked")
+
+	if terminate {
+		if task, ok := app.active.Load(id); ok {
+			task.(*activeTask).cancel()
+		}
+		if cancel, ok := app.waiting.Load(id); ok {
+			cancel.(stdcontext.CancelFunc)()
+		}
+	}
+	return nil
+}
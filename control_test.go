@@ -0,0 +1,62 @@
+package worq
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskIDs_UnmarshalJSON(t *testing.T) {
+	testCases := []struct {
+		data string
+		ids  taskIDs
+	}{
+		{`"a"`, taskIDs{"a"}},
+		{`["a", "b"]`, taskIDs{"a", "b"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.data, func(t *testing.T) {
+			var ids taskIDs
+			assert.NoError(t, json.Unmarshal([]byte(tc.data), &ids))
+			assert.Equal(t, tc.ids, ids)
+		})
+	}
+}
+
+func TestApp_handleControl(t *testing.T) {
+	app, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, app.Register("tasks.b", func(ctx Context) error { return nil }))
+	assert.NoError(t, app.Register("tasks.a", func(ctx Context) error { return nil }))
+
+	reply, err := app.handleControl(&ControlMessage{Method: ControlPing})
+	assert.NoError(t, err)
+	assert.Equal(t, okReply("pong"), reply)
+
+	reply, err = app.handleControl(&ControlMessage{Method: ControlRegistered})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tasks.a", "tasks.b"}, reply)
+
+	_, err = app.handleControl(&ControlMessage{
+		Method:    ControlRevoke,
+		Arguments: json.RawMessage(`{"task_id": "task-id"}`),
+	})
+	assert.NoError(t, err)
+	assert.True(t, app.isRevoked("task-id"))
+
+	_, err = app.handleControl(&ControlMessage{
+		Method:    ControlRateLimit,
+		Arguments: json.RawMessage(`{"task_name": "tasks.a", "rate_limit": "10/m"}`),
+	})
+	assert.NoError(t, err)
+	if registered, ok := app.taskMap.Load("tasks.a"); assert.True(t, ok) {
+		assert.Equal(t, Rate{Count: 10, Period: time.Minute}, registered.(*task).getRate())
+	}
+
+	_, err = app.handleControl(&ControlMessage{Method: ControlStats})
+	assert.Equal(t, ErrNotStarted, err)
+}
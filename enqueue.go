@@ -0,0 +1,22 @@
+package worq
+
+import (
+	stdcontext "context"
+)
+
+// EnqueueFunc sends the publishing built from the signature to the broker.
+type EnqueueFunc func(ctx stdcontext.Context, sig *Signature, pub *Publishing) error
+
+// EnqueueInterceptor wraps an EnqueueFunc to run code around every enqueue.
+// Interceptors may modify the publishing, such as adding headers or changing
+// its queue, or return an error to reject the enqueue.
+type EnqueueInterceptor func(next EnqueueFunc) EnqueueFunc
+
+// chainEnqueue wraps f in the interceptors so that the first interceptor is
+// the outermost one.
+func chainEnqueue(f EnqueueFunc, interceptors ...EnqueueInterceptor) EnqueueFunc {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		f = interceptors[i](f)
+	}
+	return f
+}
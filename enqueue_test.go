@@ -0,0 +1,139 @@
+package worq_test
+
+import (
+	stdcontext "context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	worq "github.com/jianyuan/go-worq"
+	"github.com/jianyuan/go-worq/protocols/celery"
+)
+
+func TestApp_Enqueue_interceptors(t *testing.T) {
+	errRejected := errors.New("rejected")
+
+	broker := new(worq.MockBroker)
+	app, err := worq.New(
+		worq.SetBroker(broker),
+		worq.SetBinder(celery.NewBinder()),
+		worq.UseEnqueue(
+			func(next worq.EnqueueFunc) worq.EnqueueFunc {
+				return func(ctx stdcontext.Context, sig *worq.Signature, pub *worq.Publishing) error {
+					if sig.Task == "tasks.forbidden" {
+						return errRejected
+					}
+					pub.Headers["tenant"] = "acme"
+					return next(ctx, sig, pub)
+				}
+			},
+			func(next worq.EnqueueFunc) worq.EnqueueFunc {
+				return func(ctx stdcontext.Context, sig *worq.Signature, pub *worq.Publishing) error {
+					pub.Queue = "rerouted"
+					return next(ctx, sig, pub)
+				}
+			},
+		),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = app.Enqueue(worq.NewSignature("tasks.add", nil))
+	assert.NoError(t, err)
+
+	_, err = app.Enqueue(worq.NewSignature("tasks.forbidden", nil))
+	assert.Equal(t, errRejected, err)
+
+	pubs := broker.Publishings()
+	if assert.Len(t, pubs, 1) {
+		assert.Equal(t, "acme", pubs[0].Headers["tenant"])
+		assert.Equal(t, "rerouted", pubs[0].Queue)
+	}
+}
+
+func TestApp_Enqueue_taskPriority(t *testing.T) {
+	broker := new(worq.MockBroker)
+	app, err := worq.New(
+		worq.SetBroker(broker),
+		worq.SetBinder(celery.NewBinder()),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = app.Register("tasks.urgent", func(ctx worq.Context) error {
+		return nil
+	}, worq.WithPriority(9))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = app.Enqueue(worq.NewSignature("tasks.urgent", nil))
+	assert.NoError(t, err)
+	_, err = app.Enqueue(&worq.Signature{Task: "tasks.urgent", Priority: 1})
+	assert.NoError(t, err)
+	_, err = app.Enqueue(worq.NewSignature("tasks.batch", nil))
+	assert.NoError(t, err)
+
+	pubs := broker.Publishings()
+	if assert.Len(t, pubs, 3) {
+		assert.Equal(t, uint8(9), pubs[0].Priority)
+		assert.Equal(t, uint8(1), pubs[1].Priority)
+		assert.Equal(t, uint8(0), pubs[2].Priority)
+	}
+}
+
+func TestApp_Enqueue_unique(t *testing.T) {
+	broker := new(worq.MockBroker)
+	app, err := worq.New(
+		worq.SetBroker(broker),
+		worq.SetBinder(celery.NewBinder()),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	rebuild := func(key string) *worq.Signature {
+		sig := worq.NewSignature("tasks.rebuild", map[string]string{"cache": key})
+		sig.Unique = time.Minute
+		return sig
+	}
+
+	first, err := app.Enqueue(rebuild("users"))
+	assert.NoError(t, err)
+	second, err := app.Enqueue(rebuild("users"))
+	assert.NoError(t, err)
+	other, err := app.Enqueue(rebuild("groups"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, first.ID, second.ID)
+	assert.NotEqual(t, first.ID, other.ID)
+	assert.Len(t, broker.Publishings(), 2)
+
+	// A failed enqueue does not hold the lock
+	broker.EnqueueError = errors.New("boom")
+	_, err = app.Enqueue(rebuild("posts"))
+	assert.Error(t, err)
+	broker.EnqueueError = nil
+	_, err = app.Enqueue(rebuild("posts"))
+	assert.NoError(t, err)
+	assert.Len(t, broker.Publishings(), 3)
+
+	// Nor does a publishing the broker rejects
+	broker.ConfirmError = func(pub *worq.Publishing) error {
+		return errors.New("nacked")
+	}
+	pending, err := app.EnqueueAsync(stdcontext.Background(), rebuild("jobs"))
+	if assert.NoError(t, err) {
+		assert.Error(t, pending.Wait())
+	}
+	broker.ConfirmError = nil
+	retried, err := app.Enqueue(rebuild("jobs"))
+	if assert.NoError(t, err) {
+		assert.NotEqual(t, pending.ID, retried.ID)
+	}
+	assert.Len(t, broker.Publishings(), 5)
+}
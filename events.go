@@ -0,0 +1,267 @@
+package worq
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types sent by workers. They match Celery's event types so Go workers
+// can be monitored with Celery tools such as Flower.
+const (
+	EventWorkerOnline    = "worker-online"
+	EventWorkerHeartbeat = "worker-heartbeat"
+	EventWorkerOffline   = "worker-offline"
+	EventTaskReceived    = "task-received"
+	EventTaskStarted     = "task-started"
+	EventTaskSucceeded   = "task-succeeded"
+	EventTaskFailed      = "task-failed"
+	EventTaskRetried     = "task-retried"
+	EventTaskRejected    = "task-rejected"
+	EventTaskRevoked     = "task-revoked"
+)
+
+// Event is a worker or task event in the format of Celery events. Every event
+// has at least the "type", "hostname" and "timestamp" fields.
+type Event map[string]interface{}
+
+// EventListener is called in process for every event of the worker. msg is the
+// message of the task for task events, and nil for worker events. Listeners
+// are called from the goroutine processing the task and must not block.
+type EventListener func(event Event, msg Message)
+
+// Type returns the type of the event.
+func (e Event) Type() string {
+	t, _ := e["type"].(string)
+	return t
+}
+
+// Hostname returns the hostname of the worker that sent the event.
+func (e Event) Hostname() string {
+	hostname, _ := e["hostname"].(string)
+	return hostname
+}
+
+// Time returns the time the event was sent.
+func (e Event) Time() time.Time {
+	ts, _ := e["timestamp"].(float64)
+	return time.Unix(0, int64(ts*float64(time.Second)))
+}
+
+// ConsumeEvents calls handler for every event sent by the workers until ctx
+// is done. pattern filters the events by routing key, such as "task.#" or
+// "worker.heartbeat". Every event is received if it is empty.
+func (app *App) ConsumeEvents(ctx stdcontext.Context, pattern string, handler func(Event)) error {
+	b, ok := app.broker.(Broadcaster)
+	if !ok {
+		return ErrBroadcastUnsupported
+	}
+
+	if pattern == "" {
+		pattern = "#"
+	}
+
+	consumer, err := b.Subscribe(app.Context(), app.eventExchange, pattern)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			consumer.Close()
+		case <-done:
+		}
+	}()
+
+	for consumer.Next() {
+		msg, err := consumer.Message()
+		if err != nil {
+			return err
+		}
+
+		var event Event
+		if err := json.Unmarshal(msg.Body(), &event); err != nil {
+			app.logger.Errorf("error decoding event: %v", err)
+		} else {
+			handler(event)
+		}
+
+		if err := consumer.Ack(msg); err != nil {
+			return err
+		}
+	}
+
+	if err := consumer.Err(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// sendEvent passes an event of the given type to the event listeners, and
+// broadcasts it if sending events is enabled.
+func (app *App) sendEvent(eventType string, msg Message, fields Event) {
+	b, ok := app.broker.(Broadcaster)
+	send := app.sendEvents && ok
+	if !send && len(app.eventListeners) == 0 {
+		return
+	}
+
+	now := time.Now()
+	_, offset := now.Zone()
+
+	event := Event{
+		"type":      eventType,
+		"hostname":  app.hostname,
+		"timestamp": float64(now.UnixNano()) / float64(time.Second),
+		"utcoffset": -offset / 3600,
+		"pid":       os.Getpid(),
+		"clock":     atomic.AddUint64(&app.eventClock, 1),
+	}
+	for k, v := range fields {
+		event[k] = v
+	}
+
+	for _, listener := range app.eventListeners {
+		listener(event, msg)
+	}
+
+	if !send {
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		app.logger.Errorf("error encoding event: %v", err)
+		return
+	}
+
+	pub := &Publishing{
+		ID:          app.idFunc(),
+		Queue:       strings.Replace(eventType, "-", ".", -1),
+		ContentType: "application/json",
+		Body:        body,
+	}
+
+	// A running worker sends events in the background, so that tasks do not
+	// wait for the broker
+	if w := app.currentWorker(); w != nil && w.events != nil && w.events.publish(pub) {
+		return
+	}
+
+	if err := b.Broadcast(app.eventExchange, pub); err != nil {
+		app.logger.Errorf("error sending event: %v", err)
+	}
+}
+
+// eventPublisher broadcasts events from a buffer.
+type eventPublisher struct {
+	app         *App
+	broadcaster Broadcaster
+
+	mu     sync.RWMutex
+	closed bool
+	events chan *Publishing
+	done   chan struct{}
+}
+
+// newEventPublisher starts broadcasting the events published to it, until it
+// is closed.
+func newEventPublisher(app *App, broadcaster Broadcaster, size int) *eventPublisher {
+	p := &eventPublisher{
+		app:         app,
+		broadcaster: broadcaster,
+		events:      make(chan *Publishing, size),
+		done:        make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *eventPublisher) run() {
+	defer close(p.done)
+	for pub := range p.events {
+		if err := p.broadcaster.Broadcast(p.app.eventExchange, pub); err != nil {
+			p.app.logger.Errorf("error sending event: %v", err)
+		}
+	}
+}
+
+// publish queues the event to be broadcast. It reports false if the
+// publisher is closed. Events are dropped if the buffer is full.
+func (p *eventPublisher) publish(pub *Publishing) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return false
+	}
+
+	select {
+	case p.events <- pub:
+	default:
+		p.app.logger.Warn("Event buffer is full, dropping event")
+	}
+	return true
+}
+
+// close broadcasts the events left in the buffer and stops the publisher.
+func (p *eventPublisher) close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.events)
+	}
+	p.mu.Unlock()
+
+	<-p.done
+}
+
+// sendWorkerEvent broadcasts a worker event with the state of w.
+func (app *App) sendWorkerEvent(eventType string, w *worker) {
+	var active int
+	app.active.Range(func(key, value interface{}) bool {
+		active++
+		return true
+	})
+
+	w.statsMu.Lock()
+	var processed int
+	for _, n := range w.total {
+		processed += n
+	}
+	w.statsMu.Unlock()
+
+	app.sendEvent(eventType, nil, Event{
+		"freq":      app.heartbeatInterval.Seconds(),
+		"active":    active,
+		"processed": processed,
+		"loadavg":   []float64{0, 0, 0},
+		"sw_ident":  "go-worq",
+		"sw_ver":    runtime.Version(),
+		"sw_sys":    runtime.GOOS,
+	})
+}
+
+// heartbeat sends worker heartbeats until ctx is done.
+func (app *App) heartbeat(ctx stdcontext.Context, w *worker) error {
+	ticker := time.NewTicker(app.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			app.sendWorkerEvent(EventWorkerHeartbeat, w)
+		}
+	}
+}
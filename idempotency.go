@@ -0,0 +1,79 @@
+package worq
+
+// ProcessedStore keeps track of the IDs of messages whose task has completed.
+type ProcessedStore interface {
+	Add(id string) error
+	Contains(id string) (bool, error)
+}
+
+var (
+	_ ProcessedStore = (*memoryIDSet)(nil)
+	_ ProcessedStore = (*fileIDSet)(nil)
+)
+
+// NewMemoryProcessedStore returns a ProcessedStore that remembers up to size
+// message IDs, forgetting the oldest ones first.
+func NewMemoryProcessedStore(size int) ProcessedStore {
+	return newMemoryIDSet(size)
+}
+
+// NewFileProcessedStore returns a ProcessedStore that remembers up to size
+// message IDs and persists them to the file at path, so they survive a
+// restart.
+func NewFileProcessedStore(path string, size int) (ProcessedStore, error) {
+	s, err := newFileIDSet(path, size)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// WithIdempotency records the messages of this task that completed, and
+// acknowledges redeliveries of them without running the task again.
+func WithIdempotency() TaskOptionFunc {
+	return func(t *task) error {
+		t.idempotent = true
+		return nil
+	}
+}
+
+// isIdempotent reports whether the task of the message is guarded against
+// running twice.
+func (app *App) isIdempotent(msg Message) bool {
+	t, ok := app.taskMap.Load(msg.Task())
+	return ok && t.(*task).idempotent
+}
+
+// isProcessed reports whether the message has already been processed by an
+// idempotent task.
+func (app *App) isProcessed(msg Message) bool {
+	if !app.isIdempotent(msg) {
+		return false
+	}
+
+	processed, err := app.processedStore.Contains(msg.ID())
+	if err != nil {
+		app.logger.Errorf("error checking processed messages: %v", err)
+	}
+	return processed
+}
+
+// markProcessed records that the task of the message has completed.
+func (app *App) markProcessed(msg Message) {
+	if !app.isIdempotent(msg) {
+		return
+	}
+
+	if err := app.processedStore.Add(msg.ID()); err != nil {
+		app.logger.Errorf("error recording processed message: %v", err)
+	}
+}
+
+// SetProcessedStore sets the store that records the messages processed by
+// tasks registered WithIdempotency.
+func SetProcessedStore(store ProcessedStore) OptionFunc {
+	return func(app *App) error {
+		app.processedStore = store
+		return nil
+	}
+}
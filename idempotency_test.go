@@ -0,0 +1,36 @@
+package worq
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp_handleMessage_skipsProcessedDuplicates(t *testing.T) {
+	app, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var runs int
+	task := func(ctx Context) error {
+		runs++
+		return nil
+	}
+	assert.NoError(t, app.Register("tasks.charge", task, WithIdempotency()))
+	assert.NoError(t, app.Register("tasks.log", task))
+
+	consumer := new(MockConsumer)
+	for _, msg := range []*MockMessage{
+		{MockID: "charge-id", MockTask: "tasks.charge"},
+		{MockID: "charge-id", MockTask: "tasks.charge", MockRedelivered: true},
+		{MockID: "log-id", MockTask: "tasks.log"},
+		{MockID: "log-id", MockTask: "tasks.log", MockRedelivered: true},
+	} {
+		assert.NoError(t, app.handleMessage(consumer, msg))
+	}
+
+	// Only the task registered WithIdempotency skips its duplicate
+	assert.Equal(t, 3, runs)
+	assert.Len(t, consumer.Acked(), 4)
+}
@@ -0,0 +1,170 @@
+package worq
+
+import (
+	"bufio"
+	"os"
+	"sync"
+)
+
+// memoryIDSet is a set of up to a fixed number of IDs, which forgets the
+// oldest ones first.
+type memoryIDSet struct {
+	mu   sync.Mutex
+	ids  map[string]struct{}
+	ring []string
+	next int
+}
+
+func newMemoryIDSet(size int) *memoryIDSet {
+	if size < 1 {
+		size = 1
+	}
+	return &memoryIDSet{
+		ids:  make(map[string]struct{}, size),
+		ring: make([]string, 0, size),
+	}
+}
+
+func (s *memoryIDSet) Add(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.ids[id]; ok {
+		return nil
+	}
+
+	if len(s.ring) < cap(s.ring) {
+		s.ring = append(s.ring, id)
+	} else {
+		delete(s.ids, s.ring[s.next])
+		s.ring[s.next] = id
+		s.next = (s.next + 1) % len(s.ring)
+	}
+	s.ids[id] = struct{}{}
+	return nil
+}
+
+func (s *memoryIDSet) Contains(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.ids[id]
+	return ok, nil
+}
+
+// list returns the stored IDs from oldest to newest.
+func (s *memoryIDSet) list() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, 0, len(s.ring))
+	ids = append(ids, s.ring[s.next:]...)
+	ids = append(ids, s.ring[:s.next]...)
+	return ids
+}
+
+// fileIDSet is a memoryIDSet that appends the IDs added to a file, so that
+// they survive a restart.
+type fileIDSet struct {
+	*memoryIDSet
+
+	mu    sync.Mutex
+	path  string
+	file  *os.File
+	lines int
+}
+
+// newFileIDSet returns a set of up to size IDs that is persisted to the file
+// at path.
+func newFileIDSet(path string, size int) (*fileIDSet, error) {
+	s := &fileIDSet{
+		memoryIDSet: newMemoryIDSet(size),
+		path:        path,
+	}
+
+	f, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if f != nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if id := scanner.Text(); id != "" {
+				s.memoryIDSet.Add(id)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileIDSet) Add(id string) error {
+	if ok, _ := s.memoryIDSet.Contains(id); ok {
+		return nil
+	}
+
+	s.memoryIDSet.Add(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.WriteString(id + "\n"); err != nil {
+		return err
+	}
+	s.lines++
+
+	// Rewrite the file once it holds more evicted IDs than live ones
+	if s.lines > 2*cap(s.ring) {
+		return s.compactLocked()
+	}
+	return nil
+}
+
+func (s *fileIDSet) compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compactLocked()
+}
+
+func (s *fileIDSet) compactLocked() error {
+	ids := s.list()
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, id := range ids {
+		w.WriteString(id + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.lines = len(ids)
+	return nil
+}
@@ -1,5 +1,7 @@
 package worq
 
+import "time"
+
 type Message interface {
 	Queue() string
 
@@ -13,3 +15,26 @@ type Message interface {
 
 	Body() []byte
 }
+
+// Delivery is implemented by messages that carry the metadata of their
+// delivery by the broker.
+type Delivery interface {
+	Message
+
+	// Redelivered reports whether the message may have been delivered
+	// before, such as when a worker died before acknowledging it.
+	Redelivered() bool
+
+	// DeliveryTag identifies the delivery on the channel it was received on.
+	DeliveryTag() uint64
+
+	// Timestamp returns the time the message was published, or the zero
+	// time if unknown.
+	Timestamp() time.Time
+
+	Priority() uint8
+	CorrelationID() string
+	ReplyTo() string
+	AppID() string
+	MessageID() string
+}
@@ -1,5 +1,9 @@
 package worq
 
+import "time"
+
+var _ Delivery = (*MockMessage)(nil)
+
 type MockMessage struct {
 	MockQueue       string
 	MockID          string
@@ -7,6 +11,15 @@ type MockMessage struct {
 	MockHeaders     map[string]interface{}
 	MockContentType string
 	MockBody        []byte
+
+	MockRedelivered   bool
+	MockDeliveryTag   uint64
+	MockTimestamp     time.Time
+	MockPriority      uint8
+	MockCorrelationID string
+	MockReplyTo       string
+	MockAppID         string
+	MockMessageID     string
 }
 
 func (msg *MockMessage) Queue() string {
@@ -32,3 +45,35 @@ func (msg *MockMessage) ContentType() string {
 func (msg *MockMessage) Body() []byte {
 	return msg.MockBody
 }
+
+func (msg *MockMessage) Redelivered() bool {
+	return msg.MockRedelivered
+}
+
+func (msg *MockMessage) DeliveryTag() uint64 {
+	return msg.MockDeliveryTag
+}
+
+func (msg *MockMessage) Timestamp() time.Time {
+	return msg.MockTimestamp
+}
+
+func (msg *MockMessage) Priority() uint8 {
+	return msg.MockPriority
+}
+
+func (msg *MockMessage) CorrelationID() string {
+	return msg.MockCorrelationID
+}
+
+func (msg *MockMessage) ReplyTo() string {
+	return msg.MockReplyTo
+}
+
+func (msg *MockMessage) AppID() string {
+	return msg.MockAppID
+}
+
+func (msg *MockMessage) MessageID() string {
+	return msg.MockMessageID
+}
@@ -0,0 +1,213 @@
+// Package metrics exposes Prometheus metrics for worq workers and brokers.
+//
+// A Collector observes the events of an App, and the publishings of an
+// amqpbroker.Broker:
+//
+//	collector, _ := metrics.New()
+//	broker, _ := amqpbroker.New(dial, amqpbroker.SetObserver(collector))
+//	app, _ := worq.New(
+//		worq.SetBroker(broker),
+//		worq.AddEventListener(collector.Observe),
+//	)
+//	http.Handle("/metrics", collector.Handler())
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	worq "github.com/jianyuan/go-worq"
+)
+
+// OptionFunc is a function that configures the Collector.
+type OptionFunc func(*Collector) error
+
+type Collector struct {
+	namespace string
+	registry  *prometheus.Registry
+
+	received    *prometheus.CounterVec
+	redelivered *prometheus.CounterVec
+	succeeded   *prometheus.CounterVec
+	failed      *prometheus.CounterVec
+	rejected    *prometheus.CounterVec
+	retried     *prometheus.CounterVec
+	revoked     *prometheus.CounterVec
+	inFlight    *prometheus.GaugeVec
+	duration    *prometheus.HistogramVec
+	latency     *prometheus.HistogramVec
+
+	enqueueDuration *prometheus.HistogramVec
+	enqueueErrors   *prometheus.CounterVec
+	confirmFailures *prometheus.CounterVec
+}
+
+func New(options ...OptionFunc) (*Collector, error) {
+	c := &Collector{
+		namespace: "worq",
+		registry:  prometheus.NewRegistry(),
+	}
+
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, err
+		}
+	}
+
+	taskLabels := []string{"task", "queue"}
+
+	c.received = c.counter("tasks_received_total", "Number of tasks received.", taskLabels)
+	c.redelivered = c.counter("tasks_redelivered_total", "Number of tasks received that may have been delivered before.", taskLabels)
+	c.succeeded = c.counter("tasks_succeeded_total", "Number of tasks that succeeded.", taskLabels)
+	c.failed = c.counter("tasks_failed_total", "Number of tasks that failed.", taskLabels)
+	c.rejected = c.counter("tasks_rejected_total", "Number of tasks that were rejected.", taskLabels)
+	c.retried = c.counter("tasks_retried_total", "Number of tasks that failed and were requeued.", taskLabels)
+	c.revoked = c.counter("tasks_revoked_total", "Number of revoked tasks that were discarded or terminated.", taskLabels)
+
+	c.inFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: c.namespace,
+		Name:      "tasks_in_flight",
+		Help:      "Number of tasks being executed.",
+	}, taskLabels)
+
+	c.duration = c.histogram("task_duration_seconds", "Time taken to execute tasks.", taskLabels)
+	c.latency = c.histogram("task_queue_latency_seconds", "Time between publishing a task and starting to execute it.", taskLabels)
+
+	c.enqueueDuration = c.histogram("enqueue_duration_seconds", "Time taken for publishings to be confirmed by the broker.", []string{"queue"})
+	c.enqueueErrors = c.counter("enqueue_errors_total", "Number of publishings that failed.", []string{"queue"})
+	c.confirmFailures = c.counter("enqueue_confirm_failures_total", "Number of publishings that the broker did not acknowledge.", []string{"queue"})
+
+	for _, collector := range []prometheus.Collector{
+		c.received,
+		c.redelivered,
+		c.succeeded,
+		c.failed,
+		c.rejected,
+		c.retried,
+		c.revoked,
+		c.inFlight,
+		c.duration,
+		c.latency,
+		c.enqueueDuration,
+		c.enqueueErrors,
+		c.confirmFailures,
+	} {
+		if err := c.registry.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// SetNamespace sets the namespace prefixed to the metric names. It defaults
+// to "worq".
+func SetNamespace(namespace string) OptionFunc {
+	return func(c *Collector) error {
+		c.namespace = namespace
+		return nil
+	}
+}
+
+// SetRegistry sets the registry that the metrics are registered with.
+func SetRegistry(registry *prometheus.Registry) OptionFunc {
+	return func(c *Collector) error {
+		c.registry = registry
+		return nil
+	}
+}
+
+func (c *Collector) counter(name, help string, labels []string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.namespace,
+		Name:      name,
+		Help:      help,
+	}, labels)
+}
+
+func (c *Collector) histogram(name, help string, labels []string) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: c.namespace,
+		Name:      name,
+		Help:      help,
+		Buckets:   prometheus.DefBuckets,
+	}, labels)
+}
+
+// Handler returns an HTTP handler serving the metrics.
+func (c *Collector) Handler() http.Handler {
+	return promhttp.HandlerFor(c.registry, promhttp.HandlerOpts{})
+}
+
+// Observe records a worker event. It is a worq.EventListener.
+func (c *Collector) Observe(event worq.Event, msg worq.Message) {
+	if msg == nil {
+		return
+	}
+
+	labels := prometheus.Labels{
+		"task":  msg.Task(),
+		"queue": msg.Queue(),
+	}
+
+	switch event.Type() {
+	case worq.EventTaskReceived:
+		c.received.With(labels).Inc()
+		if d, ok := msg.(worq.Delivery); ok && d.Redelivered() {
+			c.redelivered.With(labels).Inc()
+		}
+
+	case worq.EventTaskStarted:
+		c.inFlight.With(labels).Inc()
+		if d, ok := msg.(worq.Delivery); ok && !d.Timestamp().IsZero() {
+			c.latency.With(labels).Observe(event.Time().Sub(d.Timestamp()).Seconds())
+		}
+
+	case worq.EventTaskSucceeded:
+		c.succeeded.With(labels).Inc()
+		c.finished(event, labels)
+
+	case worq.EventTaskFailed:
+		c.failed.With(labels).Inc()
+		c.finished(event, labels)
+
+	case worq.EventTaskRetried:
+		c.retried.With(labels).Inc()
+		c.finished(event, labels)
+
+	case worq.EventTaskRejected:
+		c.rejected.With(labels).Inc()
+		c.inFlight.With(labels).Dec()
+
+	case worq.EventTaskRevoked:
+		c.revoked.With(labels).Inc()
+		if terminated, _ := event["terminated"].(bool); terminated {
+			c.inFlight.With(labels).Dec()
+		}
+	}
+}
+
+func (c *Collector) finished(event worq.Event, labels prometheus.Labels) {
+	c.inFlight.With(labels).Dec()
+	if runtime, ok := event["runtime"].(float64); ok {
+		c.duration.With(labels).Observe(runtime)
+	}
+}
+
+// EnqueueObserved records a publishing. It implements amqpbroker.Observer.
+func (c *Collector) EnqueueObserved(queue string, d time.Duration, err error) {
+	if err != nil {
+		c.enqueueErrors.WithLabelValues(queue).Inc()
+		return
+	}
+	c.enqueueDuration.WithLabelValues(queue).Observe(d.Seconds())
+}
+
+// ConfirmFailed records a publishing that the broker did not acknowledge. It
+// implements amqpbroker.Observer.
+func (c *Collector) ConfirmFailed(queue string) {
+	c.confirmFailures.WithLabelValues(queue).Inc()
+}
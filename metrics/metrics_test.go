@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	worq "github.com/jianyuan/go-worq"
+)
+
+func TestCollector_Observe(t *testing.T) {
+	c, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	msg := &worq.MockMessage{MockTask: "tasks.add", MockQueue: "worq", MockRedelivered: true}
+	event := func(eventType string, fields worq.Event) worq.Event {
+		fields["type"] = eventType
+		return fields
+	}
+
+	c.Observe(event(worq.EventTaskReceived, worq.Event{}), msg)
+	c.Observe(event(worq.EventTaskStarted, worq.Event{}), msg)
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.inFlight.WithLabelValues("tasks.add", "worq")))
+
+	c.Observe(event(worq.EventTaskSucceeded, worq.Event{"runtime": 0.5}), msg)
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.received.WithLabelValues("tasks.add", "worq")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.redelivered.WithLabelValues("tasks.add", "worq")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.succeeded.WithLabelValues("tasks.add", "worq")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(c.inFlight.WithLabelValues("tasks.add", "worq")))
+
+	// Worker events are ignored
+	c.Observe(event(worq.EventWorkerHeartbeat, worq.Event{}), nil)
+}
+
+func TestCollector_EnqueueObserved(t *testing.T) {
+	c, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	c.EnqueueObserved("worq", time.Millisecond, nil)
+	c.EnqueueObserved("worq", time.Millisecond, errors.New("boom"))
+	c.ConfirmFailed("worq")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.enqueueErrors.WithLabelValues("worq")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.confirmFailures.WithLabelValues("worq")))
+}
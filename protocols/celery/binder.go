@@ -3,6 +3,7 @@ package celery
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"reflect"
 
 	worq "github.com/jianyuan/go-worq"
@@ -49,6 +50,10 @@ func (Binder) Bind(ctx worq.Context, v interface{}) error {
 				return errors.New("worq: Bind(pointer to non-struct " + rt.String() + ")")
 			}
 
+			if n := rt.Elem().NumField(); len(args) > n {
+				return fmt.Errorf("worq: Bind(%s): %d positional arguments for %d fields", rt, len(args), n)
+			}
+
 			// TODO: Validation
 			for i, arg := range args {
 				f := rv.Elem().Field(i)
@@ -69,26 +74,84 @@ func (Binder) Bind(ctx worq.Context, v interface{}) error {
 func (Binder) Unbind(ctx worq.Context, id string, queue string, sig *worq.Signature) (*worq.Publishing, error) {
 	pub := new(worq.Publishing)
 
+	pub.ID = id
 	pub.Queue = queue
-
-	pub.Headers = make(map[string]interface{}, 2)
+	pub.Priority = sig.Priority
+	pub.Transient = sig.Transient
+	pub.Expiration = sig.Expiration
+	pub.CorrelationID = sig.CorrelationID
+	pub.ReplyTo = sig.ReplyTo
+	pub.Timestamp = sig.Timestamp
+
+	pub.Headers = make(map[string]interface{}, 4)
 	pub.Headers["id"] = id
 	pub.Headers["task"] = sig.Task
+	if sig.Group != "" {
+		pub.Headers["group"] = sig.Group
+		pub.Headers["group_index"] = int64(sig.GroupIndex)
+	}
+	if sig.ParentID != "" {
+		pub.Headers["parent_id"] = sig.ParentID
+	}
+	if sig.ParentError != "" {
+		pub.Headers["parent_error"] = sig.ParentError
+	}
 
 	pub.ContentType = MIMEApplicationJSON
+	pub.ContentEncoding = sig.ContentEncoding
+	if pub.ContentEncoding == "" {
+		pub.ContentEncoding = "utf-8"
+	}
 
 	body := new(TaskBody)
-	body[0] = json.RawMessage([]byte("[]"))
+
+	// Positional args
+	posArgs := sig.PosArgs
+	if posArgs == nil {
+		posArgs = []interface{}{}
+	}
+	bodyPosArgs, err := json.Marshal(posArgs)
+	if err != nil {
+		return nil, err
+	}
+	body[0] = json.RawMessage(bodyPosArgs)
 
 	// Args
-	bodyArgs, err := json.Marshal(sig.Args)
+	body[1], err = marshalKWArgs(sig.Args)
 	if err != nil {
 		return nil, err
 	}
-	body[1] = json.RawMessage(bodyArgs)
 
 	embed := new(TaskEmbed)
-	// TODO: callbacks, errbacks, chain, chord
+
+	// Celery pops the next task off the end of the chain
+	for i := len(sig.Chain) - 1; i >= 0; i-- {
+		ts, err := newTaskSignature(sig.Chain[i])
+		if err != nil {
+			return nil, err
+		}
+		embed.Chain = append(embed.Chain, ts)
+	}
+
+	if sig.Chord != nil {
+		embed.Chord, err = newTaskSignature(sig.Chord)
+		if err != nil {
+			return nil, err
+		}
+		size := sig.GroupSize
+		embed.Chord.ChordSize = &size
+	}
+
+	embed.Callbacks, err = newTaskSignatures(sig.Callbacks)
+	if err != nil {
+		return nil, err
+	}
+
+	embed.Errbacks, err = newTaskSignatures(sig.Errbacks)
+	if err != nil {
+		return nil, err
+	}
+
 	bodyEmbed, err := json.Marshal(embed)
 	if err != nil {
 		return nil, err
@@ -102,3 +165,56 @@ func (Binder) Unbind(ctx worq.Context, id string, queue string, sig *worq.Signat
 
 	return pub, nil
 }
+
+func (Binder) Signature(ctx worq.Context) (*worq.Signature, error) {
+	msg := ctx.Message()
+	if msg.ContentType() != MIMEApplicationJSON {
+		return nil, ErrUnsupportedContentType
+	}
+
+	var body TaskBody
+	if err := json.Unmarshal(msg.Body(), &body); err != nil {
+		return nil, err
+	}
+
+	sig := worq.NewSignature(msg.Task(), body[1])
+	sig.ID = msg.ID()
+
+	if err := json.Unmarshal(body[0], &sig.PosArgs); err != nil {
+		return nil, err
+	}
+
+	var embed TaskEmbed
+	if len(body[2]) > 0 {
+		if err := json.Unmarshal(body[2], &embed); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := len(embed.Chain) - 1; i >= 0; i-- {
+		sig.Chain = append(sig.Chain, embed.Chain[i].signature())
+	}
+
+	for _, ts := range embed.Callbacks {
+		sig.Callbacks = append(sig.Callbacks, ts.signature())
+	}
+
+	for _, ts := range embed.Errbacks {
+		sig.Errbacks = append(sig.Errbacks, ts.signature())
+	}
+
+	if embed.Chord != nil {
+		sig.Chord = embed.Chord.signature()
+		if embed.Chord.ChordSize != nil {
+			sig.GroupSize = *embed.Chord.ChordSize
+		}
+	}
+
+	headers := msg.Headers()
+	sig.Group, _ = amqpTableStringOk(headers, "group")
+	sig.GroupIndex, _ = amqpTableIntOk(headers, "group_index")
+	sig.ParentID, _ = amqpTableStringOk(headers, "parent_id")
+	sig.ParentError, _ = amqpTableStringOk(headers, "parent_error")
+
+	return sig, nil
+}
@@ -1,8 +1,10 @@
 package celery
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	worq "github.com/jianyuan/go-worq"
 	"github.com/stretchr/testify/assert"
@@ -33,3 +35,121 @@ func TestBinder_Bind_errors(t *testing.T) {
 		})
 	}
 }
+
+func TestBinder_Unbind_signature(t *testing.T) {
+	b := new(Binder)
+
+	sig := worq.NewChain(
+		worq.NewSignature("tasks.add", map[string]int{"x": 1}),
+		worq.NewSignature("tasks.mul", map[string]int{"y": 2}),
+		&worq.Signature{Task: "tasks.log", Immutable: true, Priority: 9, Transient: true, Expiration: time.Minute},
+	)
+	sig.Priority = 5
+	sig.Chord = &worq.Signature{ID: "body-id", Task: "tasks.sum"}
+	sig.Group = "group-id"
+	sig.GroupIndex = 1
+	sig.GroupSize = 3
+	sig.Link(worq.NewSignature("tasks.notify", nil))
+	sig.LinkError(worq.NewSignature("tasks.alert", nil))
+
+	pub, err := b.Unbind(new(worq.MockContext), "task-id", "celery", sig)
+	assert.NoError(t, err)
+	assert.Equal(t, "group-id", pub.Headers["group"])
+	assert.Equal(t, uint8(5), pub.Priority)
+	assert.Equal(t, "task-id", pub.ID)
+	assert.Equal(t, "utf-8", pub.ContentEncoding)
+	assert.True(t, pub.Timestamp.IsZero())
+
+	msg := &worq.MockMessage{
+		MockID:          "task-id",
+		MockTask:        "tasks.add",
+		MockHeaders:     pub.Headers,
+		MockContentType: pub.ContentType,
+		MockBody:        pub.Body,
+	}
+	ctx := &worq.MockContext{
+		MessageFactory: func() worq.Message { return msg },
+	}
+
+	got, err := b.Signature(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "task-id", got.ID)
+	assert.Equal(t, "tasks.add", got.Task)
+	assert.JSONEq(t, `{"x":1}`, string(got.Args.(json.RawMessage)))
+	if assert.Len(t, got.Chain, 2) {
+		assert.Equal(t, "tasks.mul", got.Chain[0].Task)
+		assert.Equal(t, "tasks.log", got.Chain[1].Task)
+		assert.True(t, got.Chain[1].Immutable)
+		assert.Equal(t, uint8(9), got.Chain[1].Priority)
+		assert.True(t, got.Chain[1].Transient)
+		assert.Equal(t, time.Minute, got.Chain[1].Expiration)
+	}
+	if assert.NotNil(t, got.Chord) {
+		assert.Equal(t, "body-id", got.Chord.ID)
+		assert.Equal(t, "tasks.sum", got.Chord.Task)
+	}
+	if assert.Len(t, got.Callbacks, 1) {
+		assert.Equal(t, "tasks.notify", got.Callbacks[0].Task)
+	}
+	if assert.Len(t, got.Errbacks, 1) {
+		assert.Equal(t, "tasks.alert", got.Errbacks[0].Task)
+	}
+	assert.Equal(t, "group-id", got.Group)
+	assert.Equal(t, 1, got.GroupIndex)
+	assert.Equal(t, 3, got.GroupSize)
+}
+
+func TestBinder_Unbind_publishing(t *testing.T) {
+	published := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	sig := &worq.Signature{Task: "tasks.add", ContentEncoding: "binary", Timestamp: published}
+
+	pub, err := new(Binder).Unbind(new(worq.MockContext), "task-id", "celery", sig)
+	assert.NoError(t, err)
+	assert.Equal(t, "binary", pub.ContentEncoding)
+	assert.Equal(t, published, pub.Timestamp)
+}
+
+func TestBinder_errback(t *testing.T) {
+	b := new(Binder)
+
+	type alertArgs struct {
+		Channel string
+	}
+
+	errback := &worq.Signature{
+		Task:        "tasks.alert",
+		PosArgs:     []interface{}{"ops"},
+		ParentID:    "task-id",
+		ParentError: "boom",
+	}
+	pub, err := b.Unbind(new(worq.MockContext), "errback-id", "celery", errback)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ctx := &worq.MockContext{
+		MessageFactory: func() worq.Message {
+			return &worq.MockMessage{
+				MockID:          "errback-id",
+				MockTask:        "tasks.alert",
+				MockHeaders:     pub.Headers,
+				MockContentType: pub.ContentType,
+				MockBody:        pub.Body,
+			}
+		},
+	}
+
+	// The parent is not passed as positional arguments
+	var args alertArgs
+	assert.NoError(t, b.Bind(ctx, &args))
+	assert.Equal(t, "ops", args.Channel)
+
+	got, err := b.Signature(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "task-id", got.ParentID)
+	assert.Equal(t, "boom", got.ParentError)
+
+	// More positional arguments than fields is an error rather than a panic
+	var empty struct{}
+	assert.Error(t, b.Bind(ctx, &empty))
+}
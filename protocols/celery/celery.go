@@ -3,6 +3,7 @@ package celery
 import (
 	"encoding/json"
 	"errors"
+	"time"
 
 	"github.com/streadway/amqp"
 
@@ -38,6 +39,22 @@ func amqpTableStringOk(t amqp.Table, key string) (string, bool) {
 	return "", false
 }
 
+func amqpTableIntOk(t amqp.Table, key string) (int, bool) {
+	switch value := t[key].(type) {
+	case int:
+		return value, true
+	case int16:
+		return int(value), true
+	case int32:
+		return int(value), true
+	case int64:
+		return int(value), true
+	case float64:
+		return int(value), true
+	}
+	return 0, false
+}
+
 func (Protocol) ID(msg worq.Message) (string, error) {
 	if id, ok := amqpTableStringOk(msg.Headers(), "id"); ok {
 		return id, nil
@@ -67,8 +84,94 @@ type TaskEmbed struct {
 }
 
 type TaskSignature struct {
-	Task    string                 `json:"task"`
-	Args    []interface{}          `json:"args"`
-	KWArgs  map[string]interface{} `json:"kwargs"`
-	Options map[string]interface{} `json:"options"`
+	Task      string                 `json:"task"`
+	Args      []interface{}          `json:"args"`
+	KWArgs    json.RawMessage        `json:"kwargs"`
+	Options   map[string]interface{} `json:"options"`
+	Immutable bool                   `json:"immutable"`
+	ChordSize *int                   `json:"chord_size,omitempty"`
+}
+
+func newTaskSignature(sig *worq.Signature) (*TaskSignature, error) {
+	kwargs, err := marshalKWArgs(sig.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := &TaskSignature{
+		Task:      sig.Task,
+		Args:      sig.PosArgs,
+		KWArgs:    kwargs,
+		Options:   make(map[string]interface{}),
+		Immutable: sig.Immutable,
+	}
+	if ts.Args == nil {
+		ts.Args = []interface{}{}
+	}
+	if sig.ID != "" {
+		ts.Options["task_id"] = sig.ID
+	}
+	if sig.Priority != 0 {
+		ts.Options["priority"] = sig.Priority
+	}
+	if sig.Transient {
+		ts.Options["delivery_mode"] = amqp.Transient
+	}
+	if sig.Expiration != 0 {
+		ts.Options["expiration"] = sig.Expiration.Seconds()
+	}
+	if sig.CorrelationID != "" {
+		ts.Options["correlation_id"] = sig.CorrelationID
+	}
+	if sig.ReplyTo != "" {
+		ts.Options["reply_to"] = sig.ReplyTo
+	}
+	return ts, nil
+}
+
+func newTaskSignatures(sigs []*worq.Signature) ([]*TaskSignature, error) {
+	var tss []*TaskSignature
+	for _, sig := range sigs {
+		ts, err := newTaskSignature(sig)
+		if err != nil {
+			return nil, err
+		}
+		tss = append(tss, ts)
+	}
+	return tss, nil
+}
+
+func (ts *TaskSignature) signature() *worq.Signature {
+	sig := worq.NewSignature(ts.Task, nil)
+	if len(ts.KWArgs) > 0 {
+		sig.Args = ts.KWArgs
+	}
+	sig.PosArgs = ts.Args
+	sig.Immutable = ts.Immutable
+	if id, ok := ts.Options["task_id"].(string); ok {
+		sig.ID = id
+	}
+	if priority, ok := amqpTableIntOk(ts.Options, "priority"); ok {
+		sig.Priority = uint8(priority)
+	}
+	if mode, ok := amqpTableIntOk(ts.Options, "delivery_mode"); ok {
+		sig.Transient = mode == int(amqp.Transient)
+	}
+	if expiration, ok := ts.Options["expiration"].(float64); ok {
+		sig.Expiration = time.Duration(expiration * float64(time.Second))
+	}
+	sig.CorrelationID, _ = amqpTableStringOk(ts.Options, "correlation_id")
+	sig.ReplyTo, _ = amqpTableStringOk(ts.Options, "reply_to")
+	return sig
+}
+
+func marshalKWArgs(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return json.RawMessage("{}"), nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(b), nil
 }
@@ -1,9 +1,33 @@
 package worq
 
+import "time"
+
 type Publishing struct {
+	// ID is the ID of the message, which is the task ID.
 	ID          string
 	Queue       string
 	Headers     map[string]interface{}
 	ContentType string
 	Body        []byte
+
+	// ContentEncoding is the encoding of the body, such as "utf-8".
+	ContentEncoding string
+
+	// Priority is the priority of the message, with higher values delivered
+	// first, if supported by the broker.
+	Priority uint8
+
+	// Transient messages are not written to disk by the broker.
+	Transient bool
+
+	// Expiration discards the message if it has not been consumed in time.
+	// Zero means it never expires.
+	Expiration time.Duration
+
+	CorrelationID string
+	ReplyTo       string
+
+	// Timestamp is the time the message was published. The broker sets it
+	// if it is zero.
+	Timestamp time.Time
 }
@@ -0,0 +1,209 @@
+package worq
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate is a number of tasks allowed per period. The zero Rate is unlimited.
+type Rate struct {
+	Count  int
+	Period time.Duration
+}
+
+// ParseRate parses a Celery-style rate limit, such as "100/m", "10/s" or
+// "1000/h". A number without a period is per second, and an empty string or
+// "0" is unlimited.
+func ParseRate(s string) (Rate, error) {
+	if s == "" {
+		return Rate{}, nil
+	}
+
+	count, unit := s, "s"
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		count, unit = s[:i], s[i+1:]
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return Rate{}, fmt.Errorf("worq: invalid rate limit %q", s)
+	}
+
+	var period time.Duration
+	switch unit {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		return Rate{}, fmt.Errorf("worq: invalid rate limit %q", s)
+	}
+
+	if n == 0 {
+		return Rate{}, nil
+	}
+	return Rate{Count: n, Period: period}, nil
+}
+
+// Unlimited reports whether the rate does not limit tasks.
+func (r Rate) Unlimited() bool {
+	return r.Count <= 0 || r.Period <= 0
+}
+
+func (r Rate) String() string {
+	if r.Unlimited() {
+		return ""
+	}
+	switch r.Period {
+	case time.Second:
+		return fmt.Sprintf("%d/s", r.Count)
+	case time.Minute:
+		return fmt.Sprintf("%d/m", r.Count)
+	case time.Hour:
+		return fmt.Sprintf("%d/h", r.Count)
+	}
+	return fmt.Sprintf("%d/%s", r.Count, r.Period)
+}
+
+// RateLimiter enforces the rate limits of tasks.
+type RateLimiter interface {
+	// Wait blocks until a task with the key may run at the rate, or ctx is
+	// done.
+	Wait(ctx stdcontext.Context, key string, rate Rate) error
+}
+
+var _ RateLimiter = (*memoryRateLimiter)(nil)
+
+// memoryRateLimiter is a RateLimiter with a token bucket per key.
+type memoryRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewMemoryRateLimiter returns a RateLimiter that limits the rate of tasks in
+// this process. Each key may burst up to the count of its rate.
+func NewMemoryRateLimiter() RateLimiter {
+	return &memoryRateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+func (l *memoryRateLimiter) Wait(ctx stdcontext.Context, key string, rate Rate) error {
+	if rate.Unlimited() {
+		return nil
+	}
+
+	for {
+		delay := l.reserve(key, rate)
+		if delay == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve takes a token from the bucket of the key, or returns how long to
+// wait until one is available.
+func (l *memoryRateLimiter) reserve(key string, rate Rate) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	capacity := float64(rate.Count)
+	perToken := rate.Period / time.Duration(rate.Count)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+
+	// Refill the bucket for the time elapsed since it was last used
+	b.tokens += float64(now.Sub(b.last)) / float64(perToken)
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(perToken))
+}
+
+// ErrUnknownTask is returned when changing a task that is not registered.
+var ErrUnknownTask = errors.New("worq: unknown task")
+
+// WithRateLimit limits how often the worker runs this task, such as "100/m".
+// Messages beyond the limit wait in the worker without being rejected.
+func WithRateLimit(limit string) TaskOptionFunc {
+	return func(t *task) error {
+		rate, err := ParseRate(limit)
+		if err != nil {
+			return err
+		}
+		t.setRate(rate)
+		return nil
+	}
+}
+
+// SetRateLimit changes the rate limit of the registered task, such as "100/m",
+// or removes it if limit is empty. Use the rate_limit control command to
+// change it on all workers.
+func (app *App) SetRateLimit(name, limit string) error {
+	rate, err := ParseRate(limit)
+	if err != nil {
+		return err
+	}
+
+	t, ok := app.taskMap.Load(name)
+	if !ok {
+		return ErrUnknownTask
+	}
+	t.(*task).setRate(rate)
+	return nil
+}
+
+// waitRateLimit blocks until the task may run under its rate limit.
+func (app *App) waitRateLimit(ctx stdcontext.Context, name string) error {
+	t, ok := app.taskMap.Load(name)
+	if !ok {
+		return nil
+	}
+
+	rate := t.(*task).getRate()
+	if rate.Unlimited() {
+		return nil
+	}
+	return app.rateLimiter.Wait(ctx, name, rate)
+}
+
+// SetRateLimiter sets the RateLimiter that enforces the rate limits of tasks.
+func SetRateLimiter(limiter RateLimiter) OptionFunc {
+	return func(app *App) error {
+		app.rateLimiter = limiter
+		return nil
+	}
+}
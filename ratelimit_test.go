@@ -0,0 +1,111 @@
+package worq
+
+import (
+	stdcontext "context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRate(t *testing.T) {
+	testCases := []struct {
+		s    string
+		rate Rate
+		err  bool
+	}{
+		{"", Rate{}, false},
+		{"0", Rate{}, false},
+		{"10", Rate{10, time.Second}, false},
+		{"100/m", Rate{100, time.Minute}, false},
+		{"1000/h", Rate{1000, time.Hour}, false},
+		{"10/d", Rate{}, true},
+		{"x/s", Rate{}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("s=%q", tc.s), func(t *testing.T) {
+			rate, err := ParseRate(tc.s)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.rate, rate)
+		})
+	}
+}
+
+func TestMemoryRateLimiter_reserve(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewMemoryRateLimiter().(*memoryRateLimiter)
+	l.now = func() time.Time { return now }
+
+	rate := Rate{Count: 2, Period: time.Second}
+
+	// The bucket starts full, allowing a burst of the count
+	assert.Zero(t, l.reserve("tasks.add", rate))
+	assert.Zero(t, l.reserve("tasks.add", rate))
+	assert.Equal(t, 500*time.Millisecond, l.reserve("tasks.add", rate))
+
+	// Other tasks have their own bucket
+	assert.Zero(t, l.reserve("tasks.mul", rate))
+
+	now = now.Add(500 * time.Millisecond)
+	assert.Zero(t, l.reserve("tasks.add", rate))
+}
+
+// blockingRateLimiter blocks until the context is cancelled or the limiter is
+// released.
+type blockingRateLimiter struct {
+	waiting chan struct{}
+	release chan struct{}
+}
+
+func (l *blockingRateLimiter) Wait(ctx stdcontext.Context, key string, rate Rate) error {
+	l.waiting <- struct{}{}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.release:
+		return nil
+	}
+}
+
+func TestApp_handleMessage_revokedWhileRateLimited(t *testing.T) {
+	for _, terminate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("terminate=%v", terminate), func(t *testing.T) {
+			limiter := &blockingRateLimiter{
+				waiting: make(chan struct{}),
+				release: make(chan struct{}),
+			}
+			app, err := New(SetBroker(new(MockBroker)), SetRateLimiter(limiter))
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			ran := false
+			assert.NoError(t, app.Register("tasks.add", func(ctx Context) error {
+				ran = true
+				return nil
+			}, WithRateLimit("1/s")))
+
+			consumer := new(MockConsumer)
+			msg := &MockMessage{MockID: "task-id", MockTask: "tasks.add"}
+			done := make(chan error)
+			go func() {
+				done <- app.handleMessage(consumer, msg)
+			}()
+
+			<-limiter.waiting
+			assert.NoError(t, app.revoke("task-id", terminate))
+			if !terminate {
+				close(limiter.release)
+			}
+
+			assert.NoError(t, <-done)
+			assert.False(t, ran)
+			assert.Len(t, consumer.Acked(), 1)
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package worq
+
+// RevokeStore keeps track of revoked task IDs.
+type RevokeStore interface {
+	Add(id string) error
+	Contains(id string) (bool, error)
+}
+
+var (
+	_ RevokeStore = (*memoryIDSet)(nil)
+	_ RevokeStore = (*fileIDSet)(nil)
+)
+
+// NewMemoryRevokeStore returns a RevokeStore that remembers up to size task
+// IDs, forgetting the oldest ones first.
+func NewMemoryRevokeStore(size int) RevokeStore {
+	return newMemoryIDSet(size)
+}
+
+// NewFileRevokeStore returns a RevokeStore that remembers up to size task IDs
+// and persists them to the file at path, so revokes survive a restart.
+func NewFileRevokeStore(path string, size int) (RevokeStore, error) {
+	s, err := newFileIDSet(path, size)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
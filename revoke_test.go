@@ -0,0 +1,51 @@
+package worq
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRevokeStore_evictsOldest(t *testing.T) {
+	store := NewMemoryRevokeStore(2)
+
+	assert.NoError(t, store.Add("a"))
+	assert.NoError(t, store.Add("b"))
+	assert.NoError(t, store.Add("c"))
+
+	for id, want := range map[string]bool{"a": false, "b": true, "c": true} {
+		ok, err := store.Contains(id)
+		assert.NoError(t, err)
+		assert.Equal(t, want, ok, id)
+	}
+}
+
+func TestFileRevokeStore_persists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worq")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "revoked")
+
+	store, err := NewFileRevokeStore(path, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		assert.NoError(t, store.Add(id))
+	}
+
+	store, err = NewFileRevokeStore(path, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for id, want := range map[string]bool{"c": false, "d": true, "e": true} {
+		ok, err := store.Contains(id)
+		assert.NoError(t, err)
+		assert.Equal(t, want, ok, id)
+	}
+}
@@ -0,0 +1,135 @@
+package worq
+
+import (
+	stdcontext "context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Semaphore limits how many tasks with the same key run at once. Holders are
+// task IDs.
+type Semaphore interface {
+	// TryAcquire takes one of the limit slots for the key on behalf of the
+	// holder, reporting false if all of them are taken.
+	TryAcquire(ctx stdcontext.Context, key, holder string, limit int) (bool, error)
+
+	// Release frees the slot of the holder.
+	Release(ctx stdcontext.Context, key, holder string) error
+}
+
+var _ Semaphore = (*memorySemaphore)(nil)
+
+type memorySemaphore struct {
+	mu      sync.Mutex
+	holders map[string]map[string]struct{}
+}
+
+// NewMemorySemaphore returns a Semaphore that limits tasks in this process.
+func NewMemorySemaphore() Semaphore {
+	return &memorySemaphore{
+		holders: make(map[string]map[string]struct{}),
+	}
+}
+
+func (s *memorySemaphore) TryAcquire(ctx stdcontext.Context, key, holder string, limit int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	holders := s.holders[key]
+	if _, ok := holders[holder]; ok {
+		return true, nil
+	}
+	if len(holders) >= limit {
+		return false, nil
+	}
+
+	if holders == nil {
+		holders = make(map[string]struct{})
+		s.holders[key] = holders
+	}
+	holders[holder] = struct{}{}
+	return true, nil
+}
+
+func (s *memorySemaphore) Release(ctx stdcontext.Context, key, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.holders[key], holder)
+	if len(s.holders[key]) == 0 {
+		delete(s.holders, key)
+	}
+	return nil
+}
+
+// WithConcurrencyLimit limits how many instances of this task run at once
+// across all workers sharing the Semaphore of the App. Messages beyond the
+// limit are requeued after a delay, without occupying a worker slot.
+func WithConcurrencyLimit(limit int) TaskOptionFunc {
+	return func(t *task) error {
+		if limit < 1 {
+			return errors.New("worq.WithConcurrencyLimit: limit must be positive")
+		}
+		t.concurrencyLimit = limit
+		return nil
+	}
+}
+
+// acquireConcurrency takes a slot of the concurrency limit of the task of
+// the message. It reports true if the task has no limit.
+func (app *App) acquireConcurrency(ctx stdcontext.Context, msg Message) (bool, error) {
+	t, ok := app.taskMap.Load(msg.Task())
+	if !ok || t.(*task).concurrencyLimit == 0 {
+		return true, nil
+	}
+	return app.semaphore.TryAcquire(ctx, msg.Task(), msg.ID(), t.(*task).concurrencyLimit)
+}
+
+// releaseConcurrency frees the slot taken by acquireConcurrency.
+func (app *App) releaseConcurrency(msg Message) {
+	t, ok := app.taskMap.Load(msg.Task())
+	if !ok || t.(*task).concurrencyLimit == 0 {
+		return
+	}
+	if err := app.semaphore.Release(stdcontext.Background(), msg.Task(), msg.ID()); err != nil {
+		app.logger.Errorf("error releasing concurrency limit of %s: %v", msg.Task(), err)
+	}
+}
+
+// holdBack puts the message back on its queue after the hold back delay.
+// Consumers that can delay messages acknowledge it right away, so it does not
+// count against the prefetch limit or go back to the head of the queue.
+// Otherwise the message stays unacknowledged until it is requeued.
+func (app *App) holdBack(consumer Consumer, msg Message) {
+	if c, ok := consumer.(DelayingConsumer); ok {
+		err := c.Delay(msg, app.holdBackDelay)
+		if err == nil {
+			return
+		}
+		app.logger.Errorf("error delaying held back task %s: %v", msg.ID(), err)
+	}
+
+	time.AfterFunc(app.holdBackDelay, func() {
+		if err := consumer.Nack(msg, true); err != nil {
+			app.logger.Errorf("error requeuing held back task %s: %v", msg.ID(), err)
+		}
+	})
+}
+
+// SetSemaphore sets the Semaphore that enforces the concurrency limits of tasks.
+func SetSemaphore(semaphore Semaphore) OptionFunc {
+	return func(app *App) error {
+		app.semaphore = semaphore
+		return nil
+	}
+}
+
+// SetHoldBackDelay sets how long messages held back by the concurrency limit
+// of their task wait before being requeued. It defaults to one second.
+func SetHoldBackDelay(d time.Duration) OptionFunc {
+	return func(app *App) error {
+		app.holdBackDelay = d
+		return nil
+	}
+}
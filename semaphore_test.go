@@ -0,0 +1,103 @@
+package worq
+
+import (
+	stdcontext "context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemorySemaphore(t *testing.T) {
+	ctx := stdcontext.Background()
+	s := NewMemorySemaphore()
+
+	for _, holder := range []string{"a", "b"} {
+		ok, err := s.TryAcquire(ctx, "tasks.migrate", holder, 2)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	ok, err := s.TryAcquire(ctx, "tasks.migrate", "c", 2)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, s.Release(ctx, "tasks.migrate", "a"))
+	ok, err = s.TryAcquire(ctx, "tasks.migrate", "c", 2)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestApp_handleMessage_holdsBackOverConcurrencyLimit(t *testing.T) {
+	app, err := New(SetHoldBackDelay(time.Millisecond))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = app.Register("tasks.migrate", func(ctx Context) error {
+		return nil
+	}, WithConcurrencyLimit(1))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Another worker is running the task
+	ok, err := app.semaphore.TryAcquire(stdcontext.Background(), "tasks.migrate", "other", 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// The message is acknowledged and delayed rather than held on to
+	consumer := new(MockConsumer)
+	msg := &MockMessage{MockID: "id", MockTask: "tasks.migrate"}
+	assert.NoError(t, app.handleMessage(consumer, msg))
+	assert.Len(t, consumer.Delayed(), 1)
+	assert.Len(t, consumer.Acked(), 1)
+
+	// It is requeued after the delay if it cannot be delayed
+	consumer = &MockConsumer{DelayError: errors.New("unsupported")}
+	assert.NoError(t, app.handleMessage(consumer, msg))
+	assert.Eventually(t, func() bool {
+		return len(consumer.Requeued()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Empty(t, consumer.Acked())
+
+	assert.NoError(t, app.semaphore.Release(stdcontext.Background(), "tasks.migrate", "other"))
+	assert.NoError(t, app.handleMessage(consumer, msg))
+	assert.Len(t, consumer.Acked(), 1)
+}
+
+func TestApp_handleMessage_waitsForRateLimitWithoutSlot(t *testing.T) {
+	limiter := &blockingRateLimiter{
+		waiting: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	app, err := New(SetRateLimiter(limiter))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = app.Register("tasks.migrate", func(ctx Context) error {
+		return nil
+	}, WithConcurrencyLimit(1), WithRateLimit("1/h"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	consumer := new(MockConsumer)
+	done := make(chan error)
+	go func() {
+		done <- app.handleMessage(consumer, &MockMessage{MockID: "id", MockTask: "tasks.migrate"})
+	}()
+
+	// Other workers can run the task while this one waits for the rate limit
+	<-limiter.waiting
+	ok, err := app.semaphore.TryAcquire(stdcontext.Background(), "tasks.migrate", "other", 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, app.semaphore.Release(stdcontext.Background(), "tasks.migrate", "other"))
+
+	close(limiter.release)
+	assert.NoError(t, <-done)
+	assert.Len(t, consumer.Acked(), 1)
+}
@@ -1,9 +1,73 @@
 package worq
 
+import "time"
+
 type Signature struct {
+	// ID is the task ID used when the signature is enqueued. A new ID is
+	// generated if it is empty.
+	ID   string
 	Task string
 	Args interface{}
-	// TODO: callbacks, errbacks, chain, chord
+
+	// PosArgs are the positional arguments of the task. The result of a
+	// parent task is prepended to them unless the signature is immutable.
+	PosArgs   []interface{}
+	Immutable bool
+
+	// Priority is the priority of the message, from 1 to 255, with higher
+	// values delivered first. Zero means the default priority of the task.
+	Priority uint8
+
+	// Transient messages are not written to disk by the broker, so they
+	// are lost if it restarts.
+	Transient bool
+
+	// Expiration discards the message if it has not been consumed in time.
+	// Zero means it never expires.
+	Expiration time.Duration
+
+	// CorrelationID and ReplyTo are set on the message for RPC-style
+	// replies.
+	CorrelationID string
+	ReplyTo       string
+
+	// ContentEncoding is the encoding of the message body, which defaults
+	// to that of the protocol.
+	ContentEncoding string
+
+	// Timestamp is the time the message was published. The broker sets it
+	// if it is zero.
+	Timestamp time.Time
+
+	// Unique makes enqueueing the signature a no-op while a signature with
+	// the same unique key is waiting to start, for at most this long; the
+	// ID of that task is returned instead. UniqueKey is the key, which
+	// defaults to a hash of the task name and arguments.
+	Unique    time.Duration
+	UniqueKey string
+
+	// Chain holds the signatures to run, in order, after this task
+	// succeeds.
+	Chain []*Signature
+
+	// Chord is the callback run once every task in the group has
+	// succeeded.
+	Chord      *Signature
+	Group      string
+	GroupIndex int
+	GroupSize  int
+
+	// Callbacks are enqueued with the result of the task once it succeeds.
+	Callbacks []*Signature
+
+	// Errbacks are enqueued once the task has failed for good.
+	Errbacks []*Signature
+
+	// ParentID and ParentError are set on errbacks to the ID and error
+	// message of the task that failed. They are not passed as arguments, so
+	// the errback binds its own arguments as usual.
+	ParentID    string
+	ParentError string
 }
 
 func NewSignature(task string, args interface{}) *Signature {
@@ -13,9 +77,56 @@ func NewSignature(task string, args interface{}) *Signature {
 	}
 }
 
+// NewChain links the signatures so that each one is enqueued with the result
+// of the previous one once it succeeds. The returned signature is the head of
+// the chain.
+func NewChain(sigs ...*Signature) *Signature {
+	if len(sigs) == 0 {
+		return nil
+	}
+	head := sigs[0].clone()
+	head.Chain = append(head.Chain, sigs[1:]...)
+	return head
+}
+
+// Link adds a callback to be enqueued with the result of the task once it
+// succeeds. It returns sig so calls can be chained.
+func (sig *Signature) Link(callback *Signature) *Signature {
+	sig.Callbacks = append(sig.Callbacks, callback)
+	return sig
+}
+
+// LinkError adds an errback to be enqueued once the task has failed for good,
+// that is when it panics or is rejected without being requeued. The errback
+// can read the ID and error of the task from the ParentID and ParentError of
+// its signature. It returns sig so calls can be chained.
+func (sig *Signature) LinkError(errback *Signature) *Signature {
+	sig.Errbacks = append(sig.Errbacks, errback)
+	return sig
+}
+
 func (sig *Signature) clone() *Signature {
 	newSig := new(Signature)
-	newSig.Task = sig.Task
-	newSig.Args = sig.Args
+	*newSig = *sig
+	newSig.PosArgs = append([]interface{}(nil), sig.PosArgs...)
+	newSig.Chain = append([]*Signature(nil), sig.Chain...)
+	newSig.Callbacks = append([]*Signature(nil), sig.Callbacks...)
+	newSig.Errbacks = append([]*Signature(nil), sig.Errbacks...)
+	return newSig
+}
+
+// withParentResult returns a copy of the signature with the parent's result
+// prepended to its positional arguments.
+func (sig *Signature) withParentResult(result interface{}) *Signature {
+	return sig.withPosArgs(result)
+}
+
+// withPosArgs returns a copy of the signature with args prepended to its
+// positional arguments, unless it is immutable.
+func (sig *Signature) withPosArgs(args ...interface{}) *Signature {
+	newSig := sig.clone()
+	if !newSig.Immutable {
+		newSig.PosArgs = append(args, newSig.PosArgs...)
+	}
 	return newSig
 }
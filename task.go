@@ -1,3 +1,70 @@
 package worq
 
+import "sync"
+
 type TaskFunc func(ctx Context) error
+
+// MiddlewareFunc wraps a TaskFunc to run code around every invocation of it.
+type MiddlewareFunc func(next TaskFunc) TaskFunc
+
+// TaskOptionFunc is a function that configures a task when it is registered.
+type TaskOptionFunc func(*task) error
+
+// task is a registered task.
+type task struct {
+	name       string
+	f          TaskFunc
+	middleware []MiddlewareFunc
+	priority   uint8
+
+	// concurrencyLimit is the number of instances that may run at once, or
+	// 0 for no limit
+	concurrencyLimit int
+	idempotent       bool
+
+	// rate may be changed while the worker is running
+	rateMu sync.Mutex
+	rate   Rate
+
+	// handler is f wrapped in the middleware
+	handler TaskFunc
+}
+
+// WithMiddleware adds middleware that only wraps this task. It runs inside
+// the middleware added to the App with Use.
+func WithMiddleware(middleware ...MiddlewareFunc) TaskOptionFunc {
+	return func(t *task) error {
+		t.middleware = append(t.middleware, middleware...)
+		return nil
+	}
+}
+
+// WithPriority sets the priority of messages for this task that are enqueued
+// by this App without a priority of their own.
+func WithPriority(priority uint8) TaskOptionFunc {
+	return func(t *task) error {
+		t.priority = priority
+		return nil
+	}
+}
+
+func (t *task) getRate() Rate {
+	t.rateMu.Lock()
+	defer t.rateMu.Unlock()
+	return t.rate
+}
+
+func (t *task) setRate(rate Rate) {
+	t.rateMu.Lock()
+	defer t.rateMu.Unlock()
+	t.rate = rate
+}
+
+// chain wraps f in the middleware so that the first middleware is the
+// outermost one.
+func chain(f TaskFunc, middleware ...MiddlewareFunc) TaskFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		f = middleware[i](f)
+	}
+	return f
+}
@@ -0,0 +1,66 @@
+package worq
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp_Register_middlewareOrder(t *testing.T) {
+	var calls []string
+	middleware := func(name string) MiddlewareFunc {
+		return func(next TaskFunc) TaskFunc {
+			return func(ctx Context) error {
+				calls = append(calls, name)
+				return next(ctx)
+			}
+		}
+	}
+
+	app, err := New(Use(middleware("global1"), middleware("global2")))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = app.Register("tasks.add", func(ctx Context) error {
+		calls = append(calls, "task")
+		return nil
+	}, WithMiddleware(middleware("task1"), middleware("task2")))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ctx := &MockContext{
+		MessageFactory: func() Message {
+			return &MockMessage{MockTask: "tasks.add"}
+		},
+	}
+	assert.NoError(t, app.processMessage(ctx))
+	assert.Equal(t, []string{"global1", "global2", "task1", "task2", "task"}, calls)
+}
+
+func TestApp_processMessage_recoversPanic(t *testing.T) {
+	app, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = app.Register("tasks.panic", func(ctx Context) error {
+		panic("boom")
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ctx := &MockContext{
+		MessageFactory: func() Message {
+			return &MockMessage{MockTask: "tasks.panic"}
+		},
+	}
+
+	err = app.processMessage(ctx)
+	if assert.IsType(t, &TaskPanicked{}, err) {
+		assert.Equal(t, "boom", err.(*TaskPanicked).Value)
+		assert.Contains(t, string(err.(*TaskPanicked).Stack), "TestApp_processMessage_recoversPanic")
+	}
+}
@@ -0,0 +1,106 @@
+package worq
+
+import (
+	stdcontext "context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/jianyuan/go-worq"
+
+// headerCarrier adapts message headers to a propagation.TextMapCarrier.
+type headerCarrier map[string]interface{}
+
+var _ propagation.TextMapCarrier = headerCarrier(nil)
+
+func (c headerCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (app *App) tracer() trace.Tracer {
+	tp := app.tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// startEnqueueSpan starts a producer span for the publishing and injects its
+// trace context into the headers.
+func (app *App) startEnqueueSpan(ctx stdcontext.Context, id string, sig *Signature, pub *Publishing) trace.Span {
+	ctx, span := app.tracer().Start(ctx, sig.Task+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "worq"),
+			attribute.String("messaging.destination.name", pub.Queue),
+			attribute.String("worq.task.name", sig.Task),
+			attribute.String("worq.task.id", id),
+		),
+	)
+
+	if pub.Headers == nil {
+		pub.Headers = make(map[string]interface{})
+	}
+	app.propagator.Inject(ctx, headerCarrier(pub.Headers))
+
+	return span
+}
+
+// startTaskSpan extracts the trace context from the message headers and
+// starts a consumer span for the task.
+func (app *App) startTaskSpan(ctx stdcontext.Context, msg Message) (stdcontext.Context, trace.Span) {
+	ctx = app.propagator.Extract(ctx, headerCarrier(msg.Headers()))
+
+	return app.tracer().Start(ctx, msg.Task(),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "worq"),
+			attribute.String("messaging.destination.name", msg.Queue()),
+			attribute.String("worq.task.name", msg.Task()),
+			attribute.String("worq.task.id", msg.ID()),
+			attribute.Int("worq.task.retries", headerInt(msg.Headers(), "retries")),
+		),
+	)
+}
+
+// endSpan records err on the span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func headerInt(headers map[string]interface{}, key string) int {
+	switch value := headers[key].(type) {
+	case int:
+		return value
+	case int16:
+		return int(value)
+	case int32:
+		return int(value)
+	case int64:
+		return int(value)
+	case float64:
+		return int(value)
+	}
+	return 0
+}
@@ -0,0 +1,33 @@
+package worq
+
+import (
+	stdcontext "context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestApp_propagatesTraceContext(t *testing.T) {
+	app, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(stdcontext.Background(), sc)
+
+	pub := new(Publishing)
+	app.startEnqueueSpan(ctx, "task-id", NewSignature("tasks.add", nil), pub).End()
+	assert.Contains(t, pub.Headers, "traceparent")
+
+	msg := &MockMessage{MockTask: "tasks.add", MockHeaders: pub.Headers}
+	taskCtx, span := app.startTaskSpan(stdcontext.Background(), msg)
+	defer span.End()
+
+	assert.Equal(t, sc.TraceID(), trace.SpanContextFromContext(taskCtx).TraceID())
+}
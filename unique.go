@@ -0,0 +1,147 @@
+package worq
+
+import (
+	stdcontext "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// LockStore holds the locks that make signatures unique.
+type LockStore interface {
+	// Lock stores id under the key for the TTL, unless the key is already
+	// locked, in which case it returns the ID stored under it and false.
+	Lock(ctx stdcontext.Context, key, id string, ttl time.Duration) (holder string, locked bool, err error)
+
+	// Unlock removes the key if it is locked by id.
+	Unlock(ctx stdcontext.Context, key, id string) error
+}
+
+var _ LockStore = (*memoryLockStore)(nil)
+
+// lockSweepInterval is how often the memory lock store drops expired locks.
+const lockSweepInterval = time.Minute
+
+type memoryLockStore struct {
+	mu    sync.Mutex
+	locks map[string]memoryLock
+	swept time.Time
+	now   func() time.Time
+}
+
+type memoryLock struct {
+	id      string
+	expires time.Time
+}
+
+// NewMemoryLockStore returns a LockStore that keeps locks in memory.
+func NewMemoryLockStore() LockStore {
+	return &memoryLockStore{
+		locks: make(map[string]memoryLock),
+		now:   time.Now,
+	}
+}
+
+func (s *memoryLockStore) Lock(ctx stdcontext.Context, key, id string, ttl time.Duration) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if lock, ok := s.locks[key]; ok && now.Before(lock.expires) {
+		return lock.id, false, nil
+	}
+
+	// Drop expired locks now and then so that the store does not grow
+	// unbounded
+	if now.Sub(s.swept) >= lockSweepInterval {
+		for k, lock := range s.locks {
+			if !now.Before(lock.expires) {
+				delete(s.locks, k)
+			}
+		}
+		s.swept = now
+	}
+
+	s.locks[key] = memoryLock{id: id, expires: now.Add(ttl)}
+	return id, true, nil
+}
+
+func (s *memoryLockStore) Unlock(ctx stdcontext.Context, key, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lock, ok := s.locks[key]; ok && lock.id == id {
+		delete(s.locks, key)
+	}
+	return nil
+}
+
+// uniqueKeyHeader is the message header holding the unique key a task was
+// enqueued with.
+const uniqueKeyHeader = "worq_unique_key"
+
+// uniqueKey returns the key that identifies duplicates of the signature,
+// which is the UniqueKey if set, or a hash of its task and arguments.
+func uniqueKey(sig *Signature) (string, error) {
+	if sig.UniqueKey != "" {
+		return "worq:unique:" + sig.UniqueKey, nil
+	}
+
+	args, err := json.Marshal([]interface{}{sig.PosArgs, sig.Args})
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(sig.Task))
+	h.Write([]byte{0})
+	h.Write(args)
+	return "worq:unique:" + sig.Task + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lockUnique locks the unique key of the signature for id. It returns the ID
+// of the task already enqueued with the key, if any.
+func (app *App) lockUnique(ctx stdcontext.Context, sig *Signature, id string) (key, existing string, err error) {
+	key, err = uniqueKey(sig)
+	if err != nil {
+		return "", "", err
+	}
+
+	holder, locked, err := app.lockStore.Lock(ctx, key, id, sig.Unique)
+	if err != nil {
+		return "", "", err
+	}
+	if !locked {
+		return key, holder, nil
+	}
+	return key, "", nil
+}
+
+// unlockUnique releases the unique key, so that a signature with it can be
+// enqueued again.
+func (app *App) unlockUnique(key, id string) {
+	if key == "" {
+		return
+	}
+	if err := app.lockStore.Unlock(stdcontext.Background(), key, id); err != nil {
+		app.logger.Errorf("error unlocking unique task %s: %v", id, err)
+	}
+}
+
+// releaseUnique releases the unique key the message was enqueued with, if
+// any.
+func (app *App) releaseUnique(msg Message) {
+	if key, ok := msg.Headers()[uniqueKeyHeader].(string); ok {
+		app.unlockUnique(key, msg.ID())
+	}
+}
+
+// SetLockStore sets the LockStore used to deduplicate unique signatures.
+func SetLockStore(store LockStore) OptionFunc {
+	return func(app *App) error {
+		app.lockStore = store
+		return nil
+	}
+}
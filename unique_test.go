@@ -0,0 +1,58 @@
+package worq
+
+import (
+	stdcontext "context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLockStore(t *testing.T) {
+	ctx := stdcontext.Background()
+	now := time.Unix(0, 0)
+	s := NewMemoryLockStore().(*memoryLockStore)
+	s.now = func() time.Time { return now }
+
+	holder, locked, err := s.Lock(ctx, "a", "first", time.Second)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	assert.Equal(t, "first", holder)
+
+	holder, locked, err = s.Lock(ctx, "a", "second", time.Second)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+	assert.Equal(t, "first", holder)
+
+	// Expired locks are taken over, and only swept once in a while
+	now = now.Add(time.Second)
+	_, locked, _ = s.Lock(ctx, "b", "third", time.Second)
+	assert.True(t, locked)
+	assert.Contains(t, s.locks, "a")
+
+	now = now.Add(lockSweepInterval)
+	_, locked, _ = s.Lock(ctx, "c", "fourth", time.Second)
+	assert.True(t, locked)
+	assert.Len(t, s.locks, 1)
+	assert.Contains(t, s.locks, "c")
+}
+
+func TestApp_handleMessage_releasesUniqueKey(t *testing.T) {
+	app, broker := newWorkflowApp(t)
+
+	sig := NewSignature("tasks.one", nil)
+	sig.Unique = time.Minute
+
+	first, err := app.Enqueue(sig)
+	assert.NoError(t, err)
+	second, err := app.Enqueue(sig)
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+
+	// The task may be enqueued again once it has started
+	assert.NoError(t, app.handleMessage(new(MockConsumer), delivered(broker.Publishings()[0])))
+	third, err := app.Enqueue(sig)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.ID, third.ID)
+	assert.Len(t, broker.Publishings(), 2)
+}
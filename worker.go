@@ -0,0 +1,211 @@
+package worq
+
+import (
+	stdcontext "context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// worker runs the consumers and the pool of goroutines processing their
+// messages while the App is started.
+type worker struct {
+	app     *App
+	ctx     stdcontext.Context
+	group   *errgroup.Group
+	stop    stdcontext.CancelFunc
+	jobs    chan *job
+	started time.Time
+
+	mu        sync.Mutex
+	slots     []chan struct{}
+	consumers map[string]Consumer
+
+	statsMu sync.Mutex
+	total   map[string]int
+
+	// events sends events in the background, if enabled
+	events *eventPublisher
+}
+
+type job struct {
+	consumer Consumer
+	msg      Message
+}
+
+// activeTask is a task being processed by the worker.
+type activeTask struct {
+	id      string
+	name    string
+	queue   string
+	started time.Time
+	cancel  stdcontext.CancelFunc
+}
+
+func newWorker(app *App, ctx stdcontext.Context, group *errgroup.Group, stop stdcontext.CancelFunc, events *eventPublisher) *worker {
+	return &worker{
+		app:       app,
+		ctx:       ctx,
+		group:     group,
+		stop:      stop,
+		jobs:      make(chan *job),
+		started:   time.Now(),
+		consumers: make(map[string]Consumer),
+		total:     make(map[string]int),
+		events:    events,
+	}
+}
+
+// concurrency returns the number of goroutines processing messages.
+func (w *worker) concurrency() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.slots)
+}
+
+// resize grows or shrinks the pool to n goroutines. Goroutines being removed
+// finish the task they are processing first.
+func (w *worker) resize(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for len(w.slots) < n {
+		stop := make(chan struct{})
+		w.slots = append(w.slots, stop)
+		w.group.Go(func() error {
+			return w.work(stop)
+		})
+	}
+
+	for len(w.slots) > n {
+		close(w.slots[len(w.slots)-1])
+		w.slots = w.slots[:len(w.slots)-1]
+	}
+}
+
+func (w *worker) work(stop <-chan struct{}) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+
+		case <-w.ctx.Done():
+			return nil
+
+		case j := <-w.jobs:
+			if err := w.app.handleMessage(j.consumer, j.msg); err != nil {
+				w.app.logger.Errorf("error consuming message: %v", err)
+			}
+
+			w.statsMu.Lock()
+			w.total[j.msg.Task()]++
+			w.statsMu.Unlock()
+		}
+	}
+}
+
+// queues returns the queues being consumed from.
+func (w *worker) queues() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	queues := make([]string, 0, len(w.consumers))
+	for queue := range w.consumers {
+		queues = append(queues, queue)
+	}
+	return queues
+}
+
+func (w *worker) addConsumer(queue string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.consumers[queue]; ok {
+		return errors.New("worq: already consuming from queue " + queue)
+	}
+
+	consumer, err := w.app.broker.Consume(w.app.Context(), queue)
+	if err != nil {
+		return err
+	}
+	w.consumers[queue] = consumer
+
+	w.group.Go(func() error {
+		return w.feed(queue, consumer)
+	})
+	return nil
+}
+
+func (w *worker) cancelConsumer(queue string) error {
+	w.mu.Lock()
+	consumer, ok := w.consumers[queue]
+	delete(w.consumers, queue)
+	w.mu.Unlock()
+
+	if !ok {
+		return errors.New("worq: not consuming from queue " + queue)
+	}
+	return consumer.Close()
+}
+
+// isCancelled reports whether the consumer of the queue has been cancelled.
+func (w *worker) isCancelled(queue string, consumer Consumer) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.consumers[queue] != consumer
+}
+
+// feed passes the messages of the consumer on to the pool until the consumer
+// is cancelled or the worker stops.
+func (w *worker) feed(queue string, consumer Consumer) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-w.ctx.Done():
+			consumer.Close()
+		case <-done:
+		}
+	}()
+
+	for consumer.Next() {
+		msg, err := consumer.Message()
+		if err != nil {
+			// The consumer may have been cancelled or closed since Next
+			// returned
+			if w.isCancelled(queue, consumer) || w.ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case w.jobs <- &job{consumer: consumer, msg: msg}:
+		case <-w.ctx.Done():
+			return consumer.Nack(msg, true)
+		}
+	}
+
+	w.mu.Lock()
+	cancelled := w.consumers[queue] != consumer
+	if !cancelled {
+		delete(w.consumers, queue)
+	}
+	w.mu.Unlock()
+
+	if err := consumer.Err(); err != nil {
+		return err
+	}
+
+	if !cancelled && w.ctx.Err() == nil {
+		return errors.New("worq: consumer for queue " + queue + " closed")
+	}
+	return nil
+}
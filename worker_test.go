@@ -0,0 +1,46 @@
+package worq
+
+import (
+	stdcontext "context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sync/errgroup"
+)
+
+// closedConsumer is closed between Next and Message, as when it is cancelled
+// while a message is being delivered.
+type closedConsumer struct {
+	MockConsumer
+	delivered bool
+}
+
+func (c *closedConsumer) Next() bool {
+	delivered := c.delivered
+	c.delivered = true
+	return !delivered
+}
+
+func (c *closedConsumer) Message() (Message, error) {
+	return nil, errors.New("consumer is closed")
+}
+
+func TestWorker_feed_cancelled(t *testing.T) {
+	app, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	defer cancel()
+	w := newWorker(app, ctx, new(errgroup.Group), cancel, nil)
+
+	// A cancelled consumer stops quietly
+	assert.NoError(t, w.feed("celery", new(closedConsumer)))
+
+	// Any other consumer fails the worker
+	consumer := new(closedConsumer)
+	w.consumers["celery"] = consumer
+	assert.Error(t, w.feed("celery", consumer))
+}
@@ -0,0 +1,226 @@
+package worq
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// jsonBinder is a Binder that encodes the whole signature as JSON, so that
+// workflows can be tested without a protocol package.
+type jsonBinder struct{}
+
+func (jsonBinder) Bind(ctx Context, v interface{}) error {
+	sig, err := jsonBinder{}.Signature(ctx)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(sig.Args)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (jsonBinder) Unbind(ctx Context, id string, queue string, sig *Signature) (*Publishing, error) {
+	sig = sig.clone()
+	sig.ID = id
+	body, err := json.Marshal(sig)
+	if err != nil {
+		return nil, err
+	}
+	return &Publishing{
+		ID:          id,
+		Queue:       queue,
+		Headers:     map[string]interface{}{"id": id, "task": sig.Task},
+		ContentType: "application/json",
+		Body:        body,
+	}, nil
+}
+
+func (jsonBinder) Signature(ctx Context) (*Signature, error) {
+	sig := new(Signature)
+	return sig, json.Unmarshal(ctx.Message().Body(), sig)
+}
+
+// delivered returns the message that the broker would deliver for pub.
+func delivered(pub *Publishing) *MockMessage {
+	return &MockMessage{
+		MockQueue:       pub.Queue,
+		MockID:          pub.ID,
+		MockTask:        pub.Headers["task"].(string),
+		MockHeaders:     pub.Headers,
+		MockContentType: pub.ContentType,
+		MockBody:        pub.Body,
+	}
+}
+
+func decodeSignature(t *testing.T, pub *Publishing) *Signature {
+	sig := new(Signature)
+	assert.NoError(t, json.Unmarshal(pub.Body, sig))
+	return sig
+}
+
+func newWorkflowApp(t *testing.T) (*App, *MockBroker) {
+	broker := new(MockBroker)
+	app, err := New(SetBroker(broker), SetBinder(jsonBinder{}))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	for name, result := range map[string]int{"tasks.one": 1, "tasks.two": 2, "tasks.three": 3} {
+		result := result
+		assert.NoError(t, app.Register(name, func(ctx Context) error {
+			ctx.SetResult(result)
+			return nil
+		}))
+	}
+	return app, broker
+}
+
+func TestApp_handleMessage_advancesChain(t *testing.T) {
+	app, broker := newWorkflowApp(t)
+
+	_, err := app.Enqueue(NewChain(
+		NewSignature("tasks.one", nil),
+		NewSignature("tasks.two", nil),
+		NewSignature("tasks.three", nil),
+	))
+	assert.NoError(t, err)
+
+	consumer := new(MockConsumer)
+	for i := 0; i < 3; i++ {
+		pubs := broker.Publishings()
+		if !assert.Len(t, pubs, i+1) {
+			return
+		}
+		assert.NoError(t, app.handleMessage(consumer, delivered(pubs[i])))
+	}
+
+	pubs := broker.Publishings()
+	assert.Len(t, pubs, 3)
+	assert.Equal(t, []interface{}{1.0}, decodeSignature(t, pubs[1]).PosArgs)
+	assert.Equal(t, []interface{}{2.0}, decodeSignature(t, pubs[2]).PosArgs)
+	assert.Len(t, consumer.Acked(), 3)
+}
+
+func TestApp_handleMessage_completesChord(t *testing.T) {
+	app, broker := newWorkflowApp(t)
+
+	_, err := app.EnqueueChord([]*Signature{
+		NewSignature("tasks.one", nil),
+		NewSignature("tasks.two", nil),
+	}, NewSignature("tasks.three", nil))
+	assert.NoError(t, err)
+
+	header := broker.Publishings()
+	if !assert.Len(t, header, 2) {
+		return
+	}
+
+	consumer := new(MockConsumer)
+	assert.NoError(t, app.handleMessage(consumer, delivered(header[1])))
+	assert.Len(t, broker.Publishings(), 2)
+	assert.NoError(t, app.handleMessage(consumer, delivered(header[0])))
+
+	pubs := broker.Publishings()
+	if assert.Len(t, pubs, 3) {
+		body := decodeSignature(t, pubs[2])
+		assert.Equal(t, "tasks.three", body.Task)
+		assert.Equal(t, []interface{}{[]interface{}{1.0, 2.0}}, body.PosArgs)
+	}
+}
+
+func TestApp_handleMessage_requeuesWhenWorkflowFails(t *testing.T) {
+	app, broker := newWorkflowApp(t)
+
+	_, err := app.Enqueue(NewChain(
+		NewSignature("tasks.one", nil),
+		NewSignature("tasks.two", nil),
+	))
+	assert.NoError(t, err)
+
+	broker.EnqueueError = errors.New("broker unavailable")
+	consumer := new(MockConsumer)
+	assert.NoError(t, app.handleMessage(consumer, delivered(broker.Publishings()[0])))
+
+	assert.Empty(t, consumer.Acked())
+	assert.Len(t, consumer.Requeued(), 1)
+}
+
+func TestApp_EnqueueChord_failures(t *testing.T) {
+	app, broker := newWorkflowApp(t)
+
+	_, err := app.EnqueueChord([]*Signature{NewSignature("tasks.one", nil)}, nil)
+	assert.Error(t, err)
+	_, err = app.EnqueueChord([]*Signature{nil}, NewSignature("tasks.three", nil))
+	assert.Error(t, err)
+
+	// Header tasks enqueued before one fails are revoked
+	_, err = app.EnqueueChord([]*Signature{
+		NewSignature("tasks.one", nil),
+		NewSignature("tasks.two", make(chan int)),
+	}, NewSignature("tasks.three", nil))
+	assert.Error(t, err)
+	if pubs := broker.Publishings(); assert.Len(t, pubs, 1) {
+		assert.True(t, app.isRevoked(pubs[0].ID))
+	}
+}
+
+func TestApp_handleMessage_callbacksAndErrbacks(t *testing.T) {
+	app, broker := newWorkflowApp(t)
+	assert.NoError(t, app.Register("tasks.retry", func(ctx Context) error {
+		return errors.New("temporary")
+	}))
+	assert.NoError(t, app.Register("tasks.panic", func(ctx Context) error {
+		panic("boom")
+	}))
+	assert.NoError(t, app.Register("tasks.reject", func(ctx Context) error {
+		return ctx.Reject(false)
+	}))
+
+	enqueue := func(task string) *Publishing {
+		sig := NewSignature(task, nil).
+			Link(NewSignature("tasks.notify", nil)).
+			LinkError(&Signature{Task: "tasks.alert", PosArgs: []interface{}{"ops"}})
+		_, err := app.Enqueue(sig)
+		assert.NoError(t, err)
+		pubs := broker.Publishings()
+		return pubs[len(pubs)-1]
+	}
+	consumer := new(MockConsumer)
+
+	// Callbacks receive the result of the task
+	assert.NoError(t, app.handleMessage(consumer, delivered(enqueue("tasks.one"))))
+	pubs := broker.Publishings()
+	if assert.Len(t, pubs, 2) {
+		callback := decodeSignature(t, pubs[1])
+		assert.Equal(t, "tasks.notify", callback.Task)
+		assert.Equal(t, []interface{}{1.0}, callback.PosArgs)
+	}
+
+	// Retried failures do not enqueue errbacks, however often they happen
+	retry := enqueue("tasks.retry")
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, app.handleMessage(consumer, delivered(retry)))
+	}
+	assert.Len(t, broker.Publishings(), 3)
+	assert.Len(t, consumer.Requeued(), 3)
+
+	// Final failures enqueue errbacks once, with the failed task as parent
+	for _, task := range []string{"tasks.panic", "tasks.reject"} {
+		pub := enqueue(task)
+		assert.NoError(t, app.handleMessage(consumer, delivered(pub)))
+
+		pubs := broker.Publishings()
+		errback := decodeSignature(t, pubs[len(pubs)-1])
+		assert.Equal(t, "tasks.alert", errback.Task)
+		assert.Equal(t, pub.ID, errback.ParentID)
+		assert.NotEmpty(t, errback.ParentError)
+		assert.Equal(t, []interface{}{"ops"}, errback.PosArgs)
+	}
+	assert.Len(t, broker.Publishings(), 7)
+	assert.Len(t, consumer.Nacked(), 2)
+}